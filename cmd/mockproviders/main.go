@@ -0,0 +1,43 @@
+// Command mockproviders serves the mock upstream APIs from core/mockproviders on a local port,
+// so that the calculator server can be run against it.
+//
+//	go run ./cmd/mockproviders -addr :8181 -scenario healthy
+//
+// The scenario can be switched while running:
+//
+//	curl -X PUT 'localhost:8181/_mock/scenario?name=429'
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/mockproviders"
+)
+
+func main() {
+	addr := flag.String("addr", ":8181", "listen address")
+	scenario := flag.String("scenario", string(mockproviders.Healthy), "initial scenario: healthy, slow, 500, 429, truncated or html")
+	delay := flag.Duration("delay", 0, "response delay of the slow scenario (default 15s)")
+	validators := flag.Int("validators", 100, "number of validators served by every API family")
+	quiet := flag.Bool("quiet", false, "do not log requests")
+	flag.Parse()
+
+	opts := mockproviders.Options{
+		Delay:      *delay,
+		Validators: mockproviders.DefaultValidators(*validators),
+	}
+	if !*quiet {
+		opts.Logger = log.New(os.Stderr, "mockproviders: ", log.LstdFlags)
+	}
+
+	server := mockproviders.New(opts)
+	if err := server.SetScenario(mockproviders.Scenario(*scenario)); err != nil {
+		log.Fatalln(err)
+	}
+
+	log.Printf("Serving mock providers on %s with scenario %q", *addr, server.Scenario())
+	log.Fatalln(http.ListenAndServe(*addr, server))
+}
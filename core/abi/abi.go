@@ -0,0 +1,163 @@
+// Package abi encodes contract calls and decodes the return data of EVM contracts and
+// precompiles following the Solidity ABI, as far as the providers in core/chains need it.
+package abi
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// WordSize is the size of an ABI word in bytes.
+const WordSize = 32
+
+// Data is ABI encoded return data.
+type Data []byte
+
+// EncodeCall returns the hex encoded call data for the 4 byte selector, given in hex, with the
+// static uint256 arguments args.
+func EncodeCall(selector string, args ...*big.Int) string {
+	var b strings.Builder
+	b.WriteString("0x")
+	b.WriteString(strings.TrimPrefix(selector, "0x"))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "%064x", arg)
+	}
+
+	return b.String()
+}
+
+// Decode parses 0x prefixed hex return data.
+func Decode(s string) (Data, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("abi: invalid hex: %w", err)
+	}
+	if len(b)%WordSize != 0 {
+		return nil, fmt.Errorf("abi: length %d is not a multiple of %d", len(b), WordSize)
+	}
+
+	return b, nil
+}
+
+// Words returns the number of words in d.
+func (d Data) Words() int {
+	return len(d) / WordSize
+}
+
+// Word returns the i-th word of d.
+func (d Data) Word(i int) ([]byte, error) {
+	if i < 0 || (i+1)*WordSize > len(d) {
+		return nil, fmt.Errorf("abi: word %d out of range of %d words", i, d.Words())
+	}
+
+	return d[i*WordSize : (i+1)*WordSize], nil
+}
+
+// Uint returns the i-th word of d as an unsigned integer.
+func (d Data) Uint(i int) (*big.Int, error) {
+	w, err := d.Word(i)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(w), nil
+}
+
+// Int returns the i-th word of d as an unsigned integer that must fit in an int.
+func (d Data) Int(i int) (int, error) {
+	n, err := d.Uint(i)
+	if err != nil {
+		return 0, err
+	}
+	if !n.IsInt64() || n.Int64() > int64(^uint(0)>>1) {
+		return 0, fmt.Errorf("abi: word %d value %s overflows int", i, n)
+	}
+
+	return int(n.Int64()), nil
+}
+
+// Bool returns the i-th word of d as a bool.
+func (d Data) Bool(i int) (bool, error) {
+	n, err := d.Uint(i)
+	if err != nil {
+		return false, err
+	}
+	if n.Cmp(big.NewInt(1)) > 0 {
+		return false, fmt.Errorf("abi: word %d value %s is not a bool", i, n)
+	}
+
+	return n.Sign() == 1, nil
+}
+
+// Address returns the i-th word of d as a 0x prefixed lowercase hex address.
+func (d Data) Address(i int) (string, error) {
+	w, err := d.Word(i)
+	if err != nil {
+		return "", err
+	}
+
+	return "0x" + hex.EncodeToString(w[WordSize-20:]), nil
+}
+
+// UintArray returns the dynamic uint array whose offset is stored in the i-th word of d.
+func (d Data) UintArray(i int) ([]*big.Int, error) {
+	start, n, err := d.array(i)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*big.Int, 0, n)
+	for j := 0; j < n; j++ {
+		item, err := d.Uint(start + j)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// AddressArray returns the dynamic address array whose offset is stored in the i-th word of d.
+func (d Data) AddressArray(i int) ([]string, error) {
+	start, n, err := d.array(i)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]string, 0, n)
+	for j := 0; j < n; j++ {
+		item, err := d.Address(start + j)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// array returns the index of the first word and the length of the dynamic array referenced by
+// the i-th word of d.
+func (d Data) array(i int) (int, int, error) {
+	offset, err := d.Int(i)
+	if err != nil {
+		return 0, 0, err
+	}
+	if offset%WordSize != 0 {
+		return 0, 0, fmt.Errorf("abi: word %d offset %d is not word aligned", i, offset)
+	}
+
+	lengthWord := offset / WordSize
+	n, err := d.Int(lengthWord)
+	if err != nil {
+		return 0, 0, err
+	}
+	if lengthWord+1+n > d.Words() {
+		return 0, 0, fmt.Errorf("abi: array of %d items at word %d exceeds %d words", n, lengthWord, d.Words())
+	}
+
+	return lengthWord + 1, n, nil
+}
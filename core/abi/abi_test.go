@@ -0,0 +1,150 @@
+package abi
+
+import (
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// words joins hex encoded words into return data.
+func words(w ...string) string {
+	var b strings.Builder
+	b.WriteString("0x")
+	for _, s := range w {
+		b.WriteString(strings.Repeat("0", 64-len(s)))
+		b.WriteString(s)
+	}
+
+	return b.String()
+}
+
+func TestEncodeCall(t *testing.T) {
+	tests := []struct {
+		selector string
+		args     []*big.Int
+		want     string
+	}{
+		{"a0e67e2b", nil, "0xa0e67e2b"},
+		{"0xe75235b8", nil, "0xe75235b8"},
+		{"1b685b9e", []*big.Int{big.NewInt(1)}, "0x1b685b9e" + strings.Repeat("0", 63) + "1"},
+		{"deadbeef", []*big.Int{big.NewInt(255), big.NewInt(16)}, "0xdeadbeef" + strings.Repeat("0", 62) + "ff" + strings.Repeat("0", 62) + "10"},
+	}
+
+	for _, tt := range tests {
+		if got := EncodeCall(tt.selector, tt.args...); got != tt.want {
+			t.Errorf("EncodeCall(%s, %v) = %s, want %s", tt.selector, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, s := range []string{"0xzz", "0x" + strings.Repeat("00", 31), "0x" + strings.Repeat("00", 33)} {
+		if _, err := Decode(s); err == nil {
+			t.Errorf("Decode(%q) succeeded, want error", s)
+		}
+	}
+}
+
+func TestScalars(t *testing.T) {
+	d, err := Decode(words("5afe", "01", "2a", "ffffffffffffffffffffffffffffffffffffffff"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := d.Int(0); err != nil || got != 0x5afe {
+		t.Errorf("Int(0) = %d, %v", got, err)
+	}
+	if got, err := d.Bool(1); err != nil || !got {
+		t.Errorf("Bool(1) = %v, %v", got, err)
+	}
+	if _, err := d.Bool(2); err == nil {
+		t.Error("Bool(2) of 42 succeeded, want error")
+	}
+	if got, err := d.Address(3); err != nil || got != "0x"+strings.Repeat("f", 40) {
+		t.Errorf("Address(3) = %s, %v", got, err)
+	}
+	if _, err := d.Uint(4); err == nil {
+		t.Error("Uint(4) of 4 words succeeded, want error")
+	}
+
+	overflow, _ := Decode("0x" + strings.Repeat("f", 64))
+	if _, err := overflow.Int(0); err == nil {
+		t.Error("Int of 2^256-1 succeeded, want error")
+	}
+}
+
+func TestArrays(t *testing.T) {
+	owner1 := "00000000000000000000000000000000000000a1"
+	owner2 := "00000000000000000000000000000000000000b2"
+
+	tests := []struct {
+		name      string
+		data      string
+		index     int
+		addresses []string
+		uints     []*big.Int
+		wantErr   bool
+	}{
+		{
+			name:      "single array",
+			data:      words("20", "2", owner1, owner2),
+			addresses: []string{"0x" + owner1, "0x" + owner2},
+			uints:     []*big.Int{big.NewInt(0xa1), big.NewInt(0xb2)},
+		},
+		{
+			name:      "empty array",
+			data:      words("20", "0"),
+			addresses: []string{},
+			uints:     []*big.Int{},
+		},
+		{
+			// A static word before the offset, as in (uint256, address[]).
+			name:      "second return value",
+			data:      words("7", "40", "1", owner2),
+			index:     1,
+			addresses: []string{"0x" + owner2},
+			uints:     []*big.Int{big.NewInt(0xb2)},
+		},
+		{
+			name:    "unaligned offset",
+			data:    words("21", "1", owner1),
+			wantErr: true,
+		},
+		{
+			name:    "length beyond data",
+			data:    words("20", "3", owner1, owner2),
+			wantErr: true,
+		},
+		{
+			name:    "offset beyond data",
+			data:    words("60", "1"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := Decode(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			addresses, err := d.AddressArray(tt.index)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AddressArray(%d) error = %v, want error %v", tt.index, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(addresses, tt.addresses) {
+				t.Errorf("AddressArray(%d) = %v, want %v", tt.index, addresses, tt.addresses)
+			}
+
+			uints, err := d.UintArray(tt.index)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UintArray(%d) error = %v, want error %v", tt.index, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(uints, tt.uints) {
+				t.Errorf("UintArray(%d) = %v, want %v", tt.index, uints, tt.uints)
+			}
+		})
+	}
+}
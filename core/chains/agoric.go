@@ -1,8 +1,11 @@
 package chains
 
-func Agoric() (int, error) {
-	validatorURL := "https://main.api.agoric.net/cosmos/staking/v1beta1/validators?page.offset=1&pagination.limit=100&status=BOND_STATUS_BONDED"
-	stakingPoolURL := "https://main.api.agoric.net/cosmos/staking/v1beta1/pool"
+import "context"
 
-	return FetchCosmosSDKNakaCoeff("agoric", validatorURL, stakingPoolURL)
+func Agoric(ctx context.Context, deps Deps) (Report, error) {
+	baseURL := deps.Endpoint("agoric", "https://main.api.agoric.net")
+	validatorURL := baseURL + "/cosmos/staking/v1beta1/validators?page.offset=1&pagination.limit=100&status=BOND_STATUS_BONDED"
+	stakingPoolURL := baseURL + "/cosmos/staking/v1beta1/pool"
+
+	return FetchCosmosSDKNakaCoeff(ctx, deps, "agoric", validatorURL, stakingPoolURL)
 }
@@ -3,17 +3,30 @@ package chains
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math/big"
 	"net/http"
 	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	utils "github.com/xenowits/nakamoto-coefficient-calculator/core/utils"
 )
 
+const (
+	AlgodAPI = "https://mainnet-api.4160.nodely.dev"
+
+	defaultAlgorandConcurrency = 8
+	defaultAlgorandTimeout     = 30 * time.Second
+	// algodAccountTimeout is the time allowed for each round of "algorand_concurrency" account
+	// queries on top of the default timeout.
+	algodAccountTimeout = 2 * time.Second
+	// defaultAlgorandMaxIdleRounds is roughly eight hours of rounds.
+	defaultAlgorandMaxIdleRounds = 10_000
+)
+
 type AlgorandValidator struct {
 	Address         string  `json:"address" `           // validator's address
 	StakeMicroAlgo  uint64  `json:"stake_micro_algo" `  // stake in micro Algos
@@ -29,51 +42,344 @@ type AlgorandValidator struct {
 
 type AlgorandResponse []AlgorandValidator
 
-func Algorand() (int, error) {
-	var votingPowers []int64
-	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancelFunc()
+// AlgodSupply is the response of the algod /v2/ledger/supply endpoint.
+type AlgodSupply struct {
+	CurrentRound uint64 `json:"current_round"`
+	OnlineMoney  uint64 `json:"online-money"`
+	TotalMoney   uint64 `json:"total-money"`
+}
 
-	// https://afmetrics.api.nodely.io/v1/api-docs/
-	url := "https://afmetrics.api.nodely.io/v1/realtime/participation/validators"
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+// AlgodAccount is the response of the algod /v2/accounts/{address} endpoint.
+type AlgodAccount struct {
+	Address           string `json:"address"`
+	Amount            uint64 `json:"amount"`
+	Status            string `json:"status"`
+	IncentiveEligible bool   `json:"incentive-eligible"`
+	LastHeartbeat     uint64 `json:"last-heartbeat"`
+	LastProposed      uint64 `json:"last-proposed"`
+	Round             uint64 `json:"round"`
+	Participation     *struct {
+		VoteLastValid uint64 `json:"vote-last-valid"`
+	} `json:"participation"`
+}
+
+// algorandParticipant is the online stake of a single account.
+type algorandParticipant struct {
+	address         string
+	stake           *big.Int
+	rewardsEligible bool
+	expired         bool
+	// lastActive is the last round the account sent a heartbeat or proposed a block, zero if the
+	// source does not report it.
+	lastActive uint64
+}
+
+// algorandSnapshot is the online stake of the network as of round. A nil total means the sum of the
+// stake of the participants.
+type algorandSnapshot struct {
+	round        uint64
+	total        *big.Int
+	participants []algorandParticipant
+}
+
+// Algorand calculates the Nakamoto coefficient over the online stake of the participating accounts.
+// Participants whose participation key has expired are excluded unless the
+// "algorand_exclude_expired" setting is "false". The coefficient over the participants eligible for
+// rewards is reported as the "rewards_eligible" metric, and the report records the round the data is
+// valid as of.
+//
+// The "algorand_source" setting selects the data source: "nodely" (default) reads the validators of
+// the nodely metrics API, while "algod" reads the online stake from an algod node's ledger supply and
+// queries the online accounts listed in the "algorand_accounts" setting, or those reported by nodely
+// if it is not set, "algorand_concurrency" at a time. Only algod reports when an account last sent a
+// heartbeat or proposed a block, so with it participants idle for more than
+// "algorand_max_idle_rounds" rounds are excluded too (0 disables the check).
+//
+// Requests time out after 30 seconds, extended for the algod account queries by 2 seconds per
+// round of "algorand_concurrency" accounts. The "algorand_timeout" setting, a duration such as
+// "5m", replaces the timeout of the whole calculation.
+func Algorand(ctx context.Context, deps Deps) (Report, error) {
+	var timeout time.Duration
+	if val := deps.Setting("algorand_timeout", ""); val != "" {
+		d, err := time.ParseDuration(val)
+		if err != nil || d <= 0 {
+			return Report{}, fmt.Errorf("invalid algorand timeout %q", val)
+		}
+		timeout = d
+
+		var cancelFunc context.CancelFunc
+		ctx, cancelFunc = context.WithTimeout(ctx, timeout)
+		defer cancelFunc()
+	}
+
+	var (
+		snapshot algorandSnapshot
+		err      error
+	)
+	switch source := deps.Setting("algorand_source", "nodely"); source {
+	case "nodely":
+		snapshot, err = algorandNodely(ctx, deps, timeout == 0)
+	case "algod":
+		snapshot, err = algorandAlgod(ctx, deps, timeout == 0)
+	default:
+		return Report{}, fmt.Errorf("unknown algorand source %q", source)
+	}
 	if err != nil {
-		log.Println(err)
-		return 0, errors.New("create get request for Algorand")
+		return Report{}, err
+	}
+
+	excludeExpired := deps.Setting("algorand_exclude_expired", "true") != "false"
+	maxIdleRounds := uint64(0)
+	if n := deps.SettingInt("algorand_max_idle_rounds", defaultAlgorandMaxIdleRounds); n > 0 {
+		maxIdleRounds = uint64(n)
+	}
+
+	var (
+		votingPowers, eligiblePowers []big.Int
+		included, excluded           = new(big.Int), new(big.Int)
+		expired, idle                int
+	)
+	for _, p := range snapshot.participants {
+		switch {
+		case excludeExpired && p.expired:
+			expired++
+			excluded.Add(excluded, p.stake)
+			continue
+		case maxIdleRounds > 0 && p.lastActive > 0 && p.lastActive+maxIdleRounds < snapshot.round:
+			idle++
+			excluded.Add(excluded, p.stake)
+			continue
+		}
+
+		included.Add(included, p.stake)
+		votingPowers = append(votingPowers, *p.stake)
+		if p.rewardsEligible {
+			eligiblePowers = append(eligiblePowers, *p.stake)
+		}
+	}
+	if len(votingPowers) == 0 {
+		return Report{}, fmt.Errorf("no participating algorand accounts found among %d", len(snapshot.participants))
+	}
+
+	// The online stake of the network includes the stake of the excluded participants.
+	totalVotingPower := new(big.Int).Set(included)
+	if snapshot.total != nil {
+		totalVotingPower.Sub(snapshot.total, excluded)
+		if totalVotingPower.Cmp(included) < 0 {
+			totalVotingPower.Set(included)
+		}
 	}
+	deps.Logger.Printf("Algorand round %d: total voting power %s, %d participants, %d with expired keys and %d idle excluded",
+		snapshot.round, totalVotingPower, len(votingPowers), expired, idle)
+
+	// Sort the voting powers in descending order since they maybe in random order.
+	sortAlgorandPowers(votingPowers)
+	sortAlgorandPowers(eligiblePowers)
+
+	// Now we're ready to calculate the nakamoto coefficient
+	nakamotoCoefficient := utils.CalcNakamotoCoefficientBigNums(totalVotingPower, votingPowers)
+	deps.Logger.Println("The Nakamoto coefficient for Algorand is", nakamotoCoefficient)
 
-	resp, err := new(http.Client).Do(req)
+	return Report{
+		Coefficient: nakamotoCoefficient,
+		Round:       snapshot.round,
+		Metrics: []Metric{{
+			Name:        "rewards_eligible",
+			Value:       utils.CalcNakamotoCoefficientBigNums(totalVotingPower, eligiblePowers),
+			Methodology: fmt.Sprintf("online stake of the %d participants eligible for rewards against the total online stake, 33%% threshold", len(eligiblePowers)),
+		}},
+	}, nil
+}
+
+func sortAlgorandPowers(powers []big.Int) {
+	sort.Slice(powers, func(i, j int) bool { return powers[i].Cmp(&powers[j]) > 0 })
+}
+
+// fetchAlgorandValidators returns the validators reported by the nodely metrics API.
+func fetchAlgorandValidators(ctx context.Context, deps Deps) (AlgorandResponse, error) {
+	// https://afmetrics.api.nodely.io/v1/api-docs/
+	url := deps.Endpoint("algorand", "https://afmetrics.api.nodely.io") + "/v1/realtime/participation/validators"
+	resp, err := deps.Get(ctx, url)
 	if err != nil {
-		log.Println(err)
-		return 0, errors.New("get request unsuccessful")
+		return nil, fmt.Errorf("get request for algorand validators: %w", err)
 	}
+	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("nodely returned status %d", resp.StatusCode)
 	}
-	resp.Body.Close()
 
 	var response AlgorandResponse
-	err = json.Unmarshal(body, &response)
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// algorandNodely returns the validators of the nodely metrics API as participants, within the
+// default timeout if defaultTimeout is set.
+func algorandNodely(ctx context.Context, deps Deps, defaultTimeout bool) (algorandSnapshot, error) {
+	if defaultTimeout {
+		var cancelFunc context.CancelFunc
+		ctx, cancelFunc = context.WithTimeout(ctx, defaultAlgorandTimeout)
+		defer cancelFunc()
+	}
+
+	response, err := fetchAlgorandValidators(ctx, deps)
 	if err != nil {
-		return 0, err
+		return algorandSnapshot{}, err
 	}
 
-	// Loop through the validators staked amounts
+	var snapshot algorandSnapshot
 	for _, val := range response {
-		votingPowers = append(votingPowers, int64(val.StakeMicroAlgo))
+		if val.AsOfRound > snapshot.round {
+			snapshot.round = val.AsOfRound
+		}
+
+		snapshot.participants = append(snapshot.participants, algorandParticipant{
+			address:         val.Address,
+			stake:           new(big.Int).SetUint64(val.StakeMicroAlgo),
+			rewardsEligible: val.RewardsEligible,
+			expired:         val.ExpiresInDays <= 0,
+		})
 	}
 
-	// Sort the voting powers in descending order since they maybe in random order.
-	sort.Slice(votingPowers, func(i, j int) bool { return votingPowers[i] > votingPowers[j] })
+	return snapshot, nil
+}
 
-	totalVotingPower := utils.CalculateTotalVotingPower(votingPowers)
-	fmt.Println("Total voting power:", totalVotingPower)
+// algorandAlgod returns the online accounts queried from an algod node as participants, with the
+// online stake of its ledger supply as total. If defaultTimeout is set, the supply and the account
+// list are read within the default timeout, and the account queries within a timeout scaled with
+// the number of accounts.
+func algorandAlgod(ctx context.Context, deps Deps, defaultTimeout bool) (algorandSnapshot, error) {
+	baseURL := deps.Endpoint("algod", AlgodAPI)
+	header := make(http.Header)
+	if token := deps.APIKeys["algod"]; token != "" {
+		header.Set("X-Algo-API-Token", token)
+	}
 
-	// Now we're ready to calculate the nakamoto coefficient
-	nakamotoCoefficient := utils.CalcNakamotoCoefficient(totalVotingPower, votingPowers)
-	fmt.Println("The Nakamoto coefficient for Algorand is", nakamotoCoefficient)
+	concurrency := deps.SettingInt("algorand_concurrency", defaultAlgorandConcurrency)
+	if concurrency < 1 {
+		return algorandSnapshot{}, fmt.Errorf("algorand concurrency must be positive")
+	}
+
+	listCtx := ctx
+	if defaultTimeout {
+		var cancelFunc context.CancelFunc
+		listCtx, cancelFunc = context.WithTimeout(ctx, defaultAlgorandTimeout)
+		defer cancelFunc()
+	}
+
+	var supply AlgodSupply
+	if err := getJSON(listCtx, deps, baseURL+"/v2/ledger/supply", header, &supply); err != nil {
+		return algorandSnapshot{}, fmt.Errorf("ledger supply: %w", err)
+	}
+
+	addresses := deps.SettingList("algorand_accounts")
+	if len(addresses) == 0 {
+		validators, err := fetchAlgorandValidators(listCtx, deps)
+		if err != nil {
+			return algorandSnapshot{}, err
+		}
+		for _, val := range validators {
+			addresses = append(addresses, val.Address)
+		}
+	}
+
+	if defaultTimeout {
+		rounds := (len(addresses) + concurrency - 1) / concurrency
+		var cancelFunc context.CancelFunc
+		ctx, cancelFunc = context.WithTimeout(ctx, defaultAlgorandTimeout+time.Duration(rounds)*algodAccountTimeout)
+		defer cancelFunc()
+	}
+
+	accounts, err := algodAccounts(ctx, deps, baseURL, header, addresses, concurrency)
+	if err != nil {
+		return algorandSnapshot{}, err
+	}
+
+	snapshot := algorandSnapshot{
+		round: supply.CurrentRound,
+		total: new(big.Int).SetUint64(supply.OnlineMoney),
+	}
+	for _, account := range accounts {
+		if account.Status != "Online" {
+			continue
+		}
+
+		p := algorandParticipant{
+			address:         account.Address,
+			stake:           new(big.Int).SetUint64(account.Amount),
+			rewardsEligible: account.IncentiveEligible,
+			lastActive:      account.LastHeartbeat,
+		}
+		if account.LastProposed > p.lastActive {
+			p.lastActive = account.LastProposed
+		}
+		if account.Participation != nil && account.Participation.VoteLastValid < account.Round {
+			p.expired = true
+		}
+
+		snapshot.participants = append(snapshot.participants, p)
+	}
+
+	return snapshot, nil
+}
+
+// algodAccounts queries the accounts at addresses, concurrency at a time. If a query fails, the
+// number of accounts read until then is logged.
+func algodAccounts(ctx context.Context, deps Deps, baseURL string, header http.Header, addresses []string, concurrency int) ([]AlgodAccount, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		accounts = make([]AlgodAccount, len(addresses))
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		read     atomic.Int64
+		sem      = make(chan struct{}, concurrency)
+	)
+
+	for i, address := range addresses {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, address string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			url := fmt.Sprintf("%s/v2/accounts/%s?exclude=all", baseURL, address)
+			if err := getJSON(ctx, deps, url, header, &accounts[i]); err != nil {
+				once.Do(func() {
+					firstErr = fmt.Errorf("account %s: %w", address, err)
+					cancel()
+				})
+				return
+			}
+			read.Add(1)
+		}(i, address)
+	}
+	wg.Wait()
+
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		deps.Logger.Printf("Read %d of %d Algorand accounts before failing", read.Load(), len(addresses))
+		return nil, firstErr
+	}
 
-	return nakamotoCoefficient, nil
+	return accounts, nil
 }
@@ -3,80 +3,157 @@ package chains
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
-	"log"
 	"math/big"
 	"net/http"
-	"strconv"
+	"sort"
+	"strings"
 	"time"
 
-	"github.com/xenowits/nakamoto-coefficient-calculator/core/utils"
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/entities"
 )
 
-const AptosValidatorsUrl = "https://fullnode.mainnet.aptoslabs.com/v1/accounts/0x1/resource/0x1::stake::ValidatorSet"
+const AptosValidatorsPath = "/v1/accounts/0x1/resource/0x1::stake::ValidatorSet"
+
+type AptosValidator struct {
+	Addr        string `json:"addr"`
+	VotingPower string `json:"voting_power"`
+}
 
 type AptosResponse struct {
 	Data struct {
-		ActiveValidators []struct {
-			VotingPower string `json:"voting_power"`
-		} `json:"active_validators"`
-		TotalVotingPower string `json:"total_voting_power"`
+		ActiveValidators  []AptosValidator `json:"active_validators"`
+		PendingActive     []AptosValidator `json:"pending_active"`
+		PendingInactive   []AptosValidator `json:"pending_inactive"`
+		TotalVotingPower  string           `json:"total_voting_power"`
+		TotalJoiningPower string           `json:"total_joining_power"`
 	} `json:"data"`
 }
 
-func Aptos() (int, error) {
-	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
+// Aptos calculates the Nakamoto coefficient over the voting power of the validators of the current
+// epoch: the active validators and the pending inactive ones, which were moved out of the active set
+// but keep voting until the epoch ends. The coefficient is calculated against the total voting power
+// reported by the validator set, and a sum that differs from it is logged.
+//
+// The coefficient of the validator set of the next epoch, the active validators and the pending
+// active ones, is reported as the "next_epoch" metric. Validators are identified by their stake
+// pool address, and the coefficient over the entities of the "aptos_entities" mapping is reported as
+// the "entities" metric.
+func Aptos(ctx context.Context, deps Deps) (Report, error) {
+	ctx, cancelFunc := context.WithTimeout(ctx, 10*time.Second)
 	defer cancelFunc()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, AptosValidatorsUrl, nil)
+	mapping, err := entities.Load(ctx, deps, deps.Setting("aptos_entities", ""))
 	if err != nil {
-		log.Println(err)
-		return 0, errors.New("could not create get request for aptos")
+		return Report{}, fmt.Errorf("failed to load entities for aptos: %w", err)
 	}
 
-	resp, err := new(http.Client).Do(req)
+	url := deps.Endpoint("aptos", "https://fullnode.mainnet.aptoslabs.com") + AptosValidatorsPath
+	resp, err := deps.Get(ctx, url)
 	if err != nil {
-		log.Println(err)
-		return 0, errors.New("get request failed for aptos")
+		return Report{}, fmt.Errorf("get request failed for aptos: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return 0, err
+		return Report{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Report{}, fmt.Errorf("aptos fullnode returned status %d", resp.StatusCode)
 	}
 
 	var response AptosResponse
 	err = json.Unmarshal(body, &response)
 	if err != nil {
-		return 0, errors.New("could not unmarshal response for aptos")
+		return Report{}, fmt.Errorf("could not unmarshal response for aptos: %w", err)
+	}
+
+	expectedTotalVotingPower, ok := new(big.Int).SetString(response.Data.TotalVotingPower, 10)
+	if !ok {
+		return Report{}, fmt.Errorf("invalid total voting power %q", response.Data.TotalVotingPower)
 	}
 
-	expectedTotalVotingPower, err := strconv.ParseInt(response.Data.TotalVotingPower, 10, 64)
+	active, err := aptosValidators(response.Data.ActiveValidators)
+	if err != nil {
+		return Report{}, fmt.Errorf("active validators: %w", err)
+	}
+	pendingActive, err := aptosValidators(response.Data.PendingActive)
+	if err != nil {
+		return Report{}, fmt.Errorf("pending active validators: %w", err)
+	}
+	pendingInactive, err := aptosValidators(response.Data.PendingInactive)
 	if err != nil {
-		return 0, errors.New("failed to convert total voting power to int64")
+		return Report{}, fmt.Errorf("pending inactive validators: %w", err)
+	}
+	current := append(active[:len(active):len(active)], pendingInactive...)
+	if len(current) == 0 {
+		return Report{}, fmt.Errorf("no active validators found for aptos")
 	}
 
-	var votingPowers []big.Int
+	calculatedTotalVotingPower := new(big.Int)
+	for _, v := range current {
+		calculatedTotalVotingPower.Add(calculatedTotalVotingPower, v.Stake)
+	}
+	if expectedTotalVotingPower.Cmp(calculatedTotalVotingPower) != 0 {
+		deps.Logger.Printf("Aptos total voting power mismatch: reported %s, validators sum to %s; using the reported total",
+			expectedTotalVotingPower, calculatedTotalVotingPower)
+	}
 
-	for _, ele := range response.Data.ActiveValidators {
-		val, _ := strconv.Atoi(ele.VotingPower)
-		votingPowers = append(votingPowers, *big.NewInt(int64(val)))
+	coefficients := entities.Calculate(current, mapping, expectedTotalVotingPower)
+
+	deps.Logger.Printf("Total voting power: %s", expectedTotalVotingPower)
+	deps.Logger.Printf("Aptos is controlled by %s", strings.Join(aptosControlling(current, coefficients.Validators), ", "))
+	deps.Logger.Printf("The Nakomoto coefficient for Aptos is %d", coefficients.Validators)
+
+	metrics := []Metric{{
+		Name:        "entities",
+		Value:       coefficients.Entities,
+		Methodology: fmt.Sprintf("validators of the current epoch grouped into %d entities by stake pool address mappings, 33%% threshold", coefficients.EntityCount),
+	}}
+
+	if len(pendingActive) > 0 || len(pendingInactive) > 0 {
+		next := append(active[:len(active):len(active)], pendingActive...)
+		metrics = append(metrics, Metric{
+			Name:  "next_epoch",
+			Value: entities.Calculate(next, nil, nil).Validators,
+			Methodology: fmt.Sprintf("voting power of the %d validators of the next epoch, %d joining and %d leaving, 33%% threshold",
+				len(next), len(pendingActive), len(pendingInactive)),
+		})
 	}
 
-	calculatedTotalVotingPower := *utils.CalculateTotalVotingPowerBigNums(votingPowers)
-	coefficient := utils.CalcNakamotoCoefficientBigNums(&calculatedTotalVotingPower, votingPowers)
+	return Report{Coefficient: coefficients.Validators, Metrics: metrics}, nil
+}
 
-	if expectedTotalVotingPower != calculatedTotalVotingPower.Int64() {
-		fmt.Printf("Expected total voting power: %d\n", expectedTotalVotingPower)
-		fmt.Printf("Calculated total voting power: %s\n", calculatedTotalVotingPower.String())
-		return 0, fmt.Errorf("total voting power mismatch: expected %d != calculated %s", expectedTotalVotingPower, calculatedTotalVotingPower.String())
+// aptosValidators parses the voting power of each validator, identified by its stake pool address.
+func aptosValidators(infos []AptosValidator) ([]entities.Validator, error) {
+	validators := make([]entities.Validator, 0, len(infos))
+	for _, ele := range infos {
+		power, ok := new(big.Int).SetString(ele.VotingPower, 10)
+		if !ok {
+			return nil, fmt.Errorf("validator %s: invalid voting power %q", ele.Addr, ele.VotingPower)
+		}
+		validators = append(validators, entities.Validator{Address: ele.Addr, Stake: power})
 	}
 
-	fmt.Printf("Total voting power: %s\n", calculatedTotalVotingPower.String())
-	fmt.Printf("The Nakomoto coefficient for Aptos is %d\n", coefficient)
+	return validators, nil
+}
+
+// aptosControlling returns the stake pool addresses of the n validators with the most voting power.
+func aptosControlling(validators []entities.Validator, n int) []string {
+	sorted := append([]entities.Validator(nil), validators...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Stake.Cmp(sorted[j].Stake) > 0
+	})
+	if n > len(sorted) {
+		n = len(sorted)
+	}
+
+	addresses := make([]string, 0, n)
+	for _, v := range sorted[:n] {
+		addresses = append(addresses, v.Address)
+	}
 
-	return coefficient, nil
+	return addresses
 }
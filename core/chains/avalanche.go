@@ -1,110 +1,180 @@
 package chains
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io/ioutil"
 	"math/big"
-	"net/http"
 	"sort"
+	"strings"
+
+	utils "github.com/xenowits/nakamoto-coefficient-calculator/core/utils"
 )
 
-type AvalancheResponse struct {
-	Jsonrpc string `json:"jsonrpc"`
-	Id      int    `json:"id"`
-	Result  struct {
-		Validators []struct {
-			Weight string `json:"weight"` // Correct field for stake amount
-		} `json:"validators"`
-	} `json:"result"`
+type AvalancheValidator struct {
+	NodeID string `json:"nodeID"`
+	// Weight is the stake of the validator itself, or its weight on a permissioned subnet or L1.
+	Weight string `json:"weight"`
+	// DelegatorWeight is the stake delegated to the validator on the primary network.
+	DelegatorWeight string `json:"delegatorWeight"`
 }
 
-// Avalanche calculates the Nakamoto coefficient for Avalanche C-Chain.
-func Avalanche() (int, error) {
-	var votingPowers []*big.Int
+type AvalancheValidators struct {
+	Validators []AvalancheValidator `json:"validators"`
+}
 
-	url := "https://api.avax.network/ext/P"
-	jsonReqData := []byte(`{"jsonrpc": "2.0","method": "platform.getCurrentValidators","params":{},"id":1}`)
+// avalancheStake is the total weight of a validator, kept alongside its node ID.
+type avalancheStake struct {
+	nodeID string
+	stake  *big.Int
+}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonReqData))
-	if err != nil {
-		return 0, fmt.Errorf("failed to create request: %v", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
+// Avalanche calculates the Nakamoto coefficient over the primary network validators, weighting
+// each one by its own stake plus the stake delegated to it. Subnets and L1s listed in the
+// "avalanche_subnets" setting, as subnet IDs optionally prefixed by a name ("dfk=<subnetID>"),
+// are reported as metrics over the weight of their own validator sets. Subnets that cannot be read
+// are logged and left out.
+func Avalanche(ctx context.Context, deps Deps) (Report, error) {
+	url := deps.Endpoint("avalanche", "https://api.avax.network") + "/ext/P"
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	validators, err := avalancheValidators(ctx, deps, url, "")
 	if err != nil {
-		return 0, fmt.Errorf("failed to make request: %v", err)
+		return Report{}, fmt.Errorf("primary network: %w", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return 0, fmt.Errorf("API request failed with status code %d", resp.StatusCode)
+	stakes, ownStakes := make([]avalancheStake, 0, len(validators)), make([]avalancheStake, 0, len(validators))
+	for _, v := range validators {
+		weight, err := parseAvalancheWeight(v.Weight)
+		if err != nil {
+			return Report{}, fmt.Errorf("validator %s: %w", v.NodeID, err)
+		}
+		delegated, err := parseAvalancheWeight(v.DelegatorWeight)
+		if err != nil {
+			return Report{}, fmt.Errorf("validator %s: %w", v.NodeID, err)
+		}
+
+		ownStakes = append(ownStakes, avalancheStake{nodeID: v.NodeID, stake: weight})
+		stakes = append(stakes, avalancheStake{nodeID: v.NodeID, stake: new(big.Int).Add(weight, delegated)})
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
+	nakamotoCoefficient, err := avalancheCoefficient(deps, "primary network", stakes)
 	if err != nil {
-		return 0, fmt.Errorf("failed to read response body: %v", err)
+		return Report{}, err
 	}
-
-	var response AvalancheResponse
-	err = json.Unmarshal(body, &response)
+	ownCoefficient, err := avalancheCoefficient(deps, "primary network without delegations", ownStakes)
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse JSON response: %v", err)
+		return Report{}, err
 	}
 
-	if len(response.Result.Validators) == 0 {
-		return 0, fmt.Errorf("no validators found in API response")
-	}
+	metrics := []Metric{{
+		Name:        "validator_stake",
+		Value:       ownCoefficient,
+		Methodology: "primary network validators weighted by their own stake only, excluding delegations, 33% threshold",
+	}}
 
-	// Parse stake amounts from "weight" field and compute total voting power
-	totalVotingPower := big.NewInt(0)
-for _, v := range response.Result.Validators {
-	if v.Weight == "" {
-		continue
-	}
+	for _, entry := range deps.SettingList("avalanche_subnets") {
+		name, subnetID := entry, entry
+		if i := strings.Index(entry, "="); i >= 0 {
+			name, subnetID = strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:])
+		}
 
-	stake := new(big.Int)
-	stakeFloat := new(big.Float)
+		value, err := avalancheSubnetCoefficient(ctx, deps, url, subnetID)
+		if err != nil {
+			deps.Logger.Printf("Skipping Avalanche subnet %s: %v", name, err)
+			continue
+		}
 
-	if _, success := stakeFloat.SetString(v.Weight); success {
-		stakeFloat.Int(stake)
-	} else if _, success := stake.SetString(v.Weight, 10); !success {
-		continue
+		metrics = append(metrics, Metric{
+			Name:        "subnet_" + name,
+			Value:       value,
+			Methodology: fmt.Sprintf("validators of subnet %s weighted by their subnet weight, 33%% threshold", subnetID),
+		})
 	}
 
-	votingPowers = append(votingPowers, stake)
-	totalVotingPower.Add(totalVotingPower, stake)
+	deps.Logger.Println("The Nakamoto coefficient for Avalanche is", nakamotoCoefficient)
+
+	return Report{Coefficient: nakamotoCoefficient, Metrics: metrics}, nil
 }
 
-	if totalVotingPower.Cmp(big.NewInt(0)) == 0 {
-		return 0, fmt.Errorf("total voting power is still 0, check API response")
+// avalancheValidators returns the current validators of subnetID, or of the primary network if
+// subnetID is empty.
+func avalancheValidators(ctx context.Context, deps Deps, url, subnetID string) ([]AvalancheValidator, error) {
+	params := map[string]interface{}{}
+	if subnetID != "" {
+		params["subnetID"] = subnetID
 	}
 
-	// Sort voting powers in descending order
-	sort.Slice(votingPowers, func(i, j int) bool {
-		return votingPowers[i].Cmp(votingPowers[j]) > 0
-	})
+	var response AvalancheValidators
+	if err := deps.RPC(url).Call(ctx, "platform.getCurrentValidators", params, &response); err != nil {
+		return nil, fmt.Errorf("failed to fetch validators: %w", err)
+	}
+	if len(response.Validators) == 0 {
+		return nil, fmt.Errorf("no validators found in API response")
+	}
 
-	// Calculate Nakamoto coefficient (top n validators > 33% of stake)
-	threshold := new(big.Int).Div(new(big.Int).Mul(totalVotingPower, big.NewInt(33)), big.NewInt(100)) // 33% of total stake
-	accumulatedPower := big.NewInt(0)
-	nakamotoCoefficient := 0
+	return response.Validators, nil
+}
 
-	for _, power := range votingPowers {
-		accumulatedPower.Add(accumulatedPower, power)
-		nakamotoCoefficient++
+func avalancheSubnetCoefficient(ctx context.Context, deps Deps, url, subnetID string) (int, error) {
+	validators, err := avalancheValidators(ctx, deps, url, subnetID)
+	if err != nil {
+		return 0, err
+	}
 
-		if accumulatedPower.Cmp(threshold) >= 0 {
-			break
+	stakes := make([]avalancheStake, 0, len(validators))
+	for _, v := range validators {
+		weight, err := parseAvalancheWeight(v.Weight)
+		if err != nil {
+			return 0, fmt.Errorf("validator %s: %w", v.NodeID, err)
 		}
+		stakes = append(stakes, avalancheStake{nodeID: v.NodeID, stake: weight})
 	}
 
-	// Final result output
-	fmt.Println("Total voting power:", totalVotingPower)
-	fmt.Println("The Nakamoto coefficient for Avalanche is", nakamotoCoefficient)
+	return avalancheCoefficient(deps, "subnet "+subnetID, stakes)
+}
+
+// avalancheCoefficient sorts stakes in descending order and returns the number of validators
+// needed to reach 33% of the total, logging their node IDs.
+func avalancheCoefficient(deps Deps, label string, stakes []avalancheStake) (int, error) {
+	sort.SliceStable(stakes, func(i, j int) bool {
+		return stakes[i].stake.Cmp(stakes[j].stake) > 0
+	})
+
+	votingPowers := make([]int64, 0, len(stakes))
+	totalVotingPower := big.NewInt(0)
+	for _, s := range stakes {
+		votingPowers = append(votingPowers, s.stake.Int64())
+		totalVotingPower.Add(totalVotingPower, s.stake)
+	}
+	if totalVotingPower.Sign() == 0 {
+		return 0, fmt.Errorf("total voting power of %s is 0, check API response", label)
+	}
+	// Weights are in nAVAX, so even the whole AVAX supply fits in an int64.
+	if !totalVotingPower.IsInt64() {
+		return 0, fmt.Errorf("total voting power of %s overflows int64: %s", label, totalVotingPower)
+	}
+
+	nakamotoCoefficient := utils.CalcNakamotoCoefficient(totalVotingPower.Int64(), votingPowers)
+
+	nodeIDs := make([]string, 0, nakamotoCoefficient)
+	for _, s := range stakes[:nakamotoCoefficient] {
+		nodeIDs = append(nodeIDs, s.nodeID)
+	}
+	deps.Logger.Printf("Avalanche %s: %d validators with total weight %s, controlled by %s",
+		label, len(stakes), totalVotingPower, strings.Join(nodeIDs, ", "))
 
 	return nakamotoCoefficient, nil
 }
+
+// parseAvalancheWeight parses a weight in nAVAX, treating an empty string as no weight.
+func parseAvalancheWeight(weight string) (*big.Int, error) {
+	if weight == "" {
+		return big.NewInt(0), nil
+	}
+
+	w, ok := new(big.Int).SetString(weight, 10)
+	if !ok || w.Sign() < 0 {
+		return nil, fmt.Errorf("invalid weight %q", weight)
+	}
+
+	return w, nil
+}
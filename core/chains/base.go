@@ -1,37 +1,21 @@
 package chains
 
 import (
-	"bytes"
 	"context"
-	"fmt"
-	"net/http"
-	"time"
 )
 
-func Base() (int, error) {
-	url := "https://mainnet.base.org"
+// BaseSystemConfig is the L1 SystemConfig contract of Base mainnet.
+const BaseSystemConfig = "0x73a79Fab69143498Ed3712e519A88a918e1f4072"
 
-	payload := []byte(`{"jsonrpc":"2.0","method":"eth_blockNumber","params":[],"id":1}`)
-	
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(payload))
-	if err != nil {
-		return 0, fmt.Errorf("failed to create base request: %v", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return 0, fmt.Errorf("base rpc unreachable: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return 0, fmt.Errorf("base rpc returned status: %d", resp.StatusCode)
+// Base measures the batches posted by the batcher of Base over a recent L1 block window and the keys
+// controlling its L1 contracts, discovered from the SystemConfig contract set by the
+// "base_system_config" setting.
+func Base(ctx context.Context, deps Deps) (Report, error) {
+	control := opStackControl{
+		deps:         deps,
+		l1RPC:        deps.Endpoint("ethereum_rpc", EthereumRPC),
+		systemConfig: deps.Setting("base_system_config", BaseSystemConfig),
 	}
 
-	return 1, nil
-}
\ No newline at end of file
+	return measureRollup(ctx, deps, "base", control)
+}
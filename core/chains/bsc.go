@@ -1,31 +1,43 @@
 package chains
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"io"
 	"math/big"
 	"net/http"
+	"strings"
 
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/abi"
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/jsonrpc"
 	utils "github.com/xenowits/nakamoto-coefficient-calculator/core/utils"
 )
 
-type Request struct {
-	height   int
-	page     int
-	per_page int
+const (
+	BscRPC = "https://bsc-dataseed.bnbchain.org"
+
+	// bscValidatorSetContract reports the consensus addresses of the current active set.
+	bscValidatorSetContract = "0x0000000000000000000000000000000000001000"
+	// bscStakeHubContract maps operator addresses to consensus addresses.
+	bscStakeHubContract = "0x0000000000000000000000000000000000002002"
+
+	selectorBscGetValidators       = "b7ab4db5"
+	selectorBscGetConsensusAddress = "059ddd22"
+	bscStakingPageLimit            = 50
+	defaultBscConsensusBatchSize   = 50
+)
+
+type BscValidator struct {
+	OperatorAddress string `json:"operatorAddress"`
+	TotalStaked     string `json:"totalStaked"`
 }
 
 type BscResponse struct {
 	Code int `json:"code"`
 	Data struct {
-		Total      int `json:"total"`
-		Validators []struct {
-			OperatorAddress string `json:"operatorAddress"`
-			Moniker         string `json:"moniker"`
-			TotalStaked     string `json:"totalStaked"`
-		} `json:"validators"`
+		Total      int            `json:"total"`
+		Validators []BscValidator `json:"validators"`
 	} `json:"data"`
 }
 
@@ -35,60 +47,191 @@ type BscErrorResponse struct {
 	Error   string `json:"error"`
 }
 
-// https://api.bnbchain.org/bnb-staking/v1/validator/all?limit=100&offset=0
-func BSC() (int, error) {
+// BSC calculates the Nakamoto coefficient over the total stake of the validators in the current
+// consensus active set, as reported by getValidators of the validator set contract. Stakes are
+// read from the BNB staking API and operators are matched to consensus addresses through the
+// StakeHub contract. Validators outside the active set are reported as a metric.
+func BSC(ctx context.Context, deps Deps) (Report, error) {
+	validators, err := fetchBscValidators(ctx, deps, deps.Endpoint("bsc", "https://api.bnbchain.org"))
+	if err != nil {
+		return Report{}, err
+	}
+
+	rpcURL := deps.Endpoint("bsc_rpc", BscRPC)
+
+	active, err := bscActiveSet(ctx, deps, rpcURL)
+	if err != nil {
+		return Report{}, fmt.Errorf("active set: %w", err)
+	}
+
+	consensusAddrs, err := bscConsensusAddresses(ctx, deps, rpcURL, validators)
+	if err != nil {
+		return Report{}, fmt.Errorf("consensus addresses: %w", err)
+	}
+
 	totalVotingPower := int64(0)
-	votingPowers := make([]int64, 0, 200)
-	pageLimit, pageOffset := 50, 0
-	url := ""
-	for true {
-		url = fmt.Sprintf("https://api.bnbchain.org/bnb-staking/v1/validator/all?limit=%d&offset=%d", pageLimit, pageOffset)
-		resp, err := http.Get(url)
+	votingPowers := make([]int64, 0, len(active))
+	for i, v := range validators {
+		if !active[consensusAddrs[i]] {
+			continue
+		}
+
+		stake, ok := new(big.Int).SetString(v.TotalStaked, 10)
+		if !ok {
+			return Report{}, fmt.Errorf("validator %s: invalid total stake %q", v.OperatorAddress, v.TotalStaked)
+		}
+		votingPowers = append(votingPowers, weiToEther(stake))
+		totalVotingPower += weiToEther(stake)
+	}
+	if len(votingPowers) == 0 {
+		return Report{}, fmt.Errorf("none of %d validators are in the active set of %d", len(validators), len(active))
+	}
+
+	// now we're ready to calculate the nakomoto coefficient
+	nakamotoCoefficient := utils.CalcNakamotoCoefficient(totalVotingPower, votingPowers)
+	deps.Logger.Printf("BNB Smart Chain: %d active validators with %d BNB staked", len(votingPowers), totalVotingPower)
+	deps.Logger.Println("The Nakamoto coefficient for BNB Smart Chain is", nakamotoCoefficient)
+
+	return Report{
+		Coefficient: nakamotoCoefficient,
+		Metrics: []Metric{{
+			Name:        "inactive_validators",
+			Value:       len(validators) - len(votingPowers),
+			Methodology: "validators known to the staking API outside the current consensus active set, excluded from the coefficient",
+		}},
+	}, nil
+}
+
+// fetchBscValidators pages through all validators of the BNB staking API.
+// https://api.bnbchain.org/bnb-staking/v1/validator/all?limit=100&offset=0
+func fetchBscValidators(ctx context.Context, deps Deps, baseURL string) ([]BscValidator, error) {
+	var validators []BscValidator
+	for offset := 0; ; offset += bscStakingPageLimit {
+		url := fmt.Sprintf("%s/bnb-staking/v1/validator/all?limit=%d&offset=%d", baseURL, bscStakingPageLimit, offset)
+		resp, err := deps.Get(ctx, url)
 		if err != nil {
-			errBody, _ := ioutil.ReadAll(resp.Body)
-			var errResp BscErrorResponse
-			json.Unmarshal(errBody, &errResp)
-			log.Println(errResp.Error)
-			return 0, err
+			return nil, err
 		}
-		defer resp.Body.Close()
 
-		body, err := ioutil.ReadAll(resp.Body)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
 		if err != nil {
-			return 0, err
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			var errResp BscErrorResponse
+			if json.Unmarshal(body, &errResp) == nil && errResp.Error != "" {
+				return nil, fmt.Errorf("staking API returned status %d: %s", resp.StatusCode, errResp.Error)
+			}
+			return nil, fmt.Errorf("staking API returned status %d", resp.StatusCode)
 		}
 
 		var response BscResponse
-		err = json.Unmarshal(body, &response)
-		if err != nil {
-			return 0, err
+		if err := json.Unmarshal(body, &response); err != nil {
+			return nil, err
 		}
 
 		// break if no more entries left
 		if len(response.Data.Validators) == 0 {
 			break
 		}
+		validators = append(validators, response.Data.Validators...)
 
-		// loop through the validators voting power proportions
-		for _, ele := range response.Data.Validators {
-			totalStaked := ele.TotalStaked
-			wei, _ := new(big.Int).SetString(totalStaked, 10)
-			votingPowers = append(votingPowers, weiToEther(wei))
-			totalVotingPower += weiToEther(wei)
+		if response.Data.Total > 0 && len(validators) >= response.Data.Total {
+			break
 		}
+	}
 
-		// increment counters
-		pageOffset += pageLimit
+	if len(validators) == 0 {
+		return nil, fmt.Errorf("no validators found")
 	}
 
-	// NOTE: NO need to calculate total voting power as the API response already
-	// sends us proportional shares of each validator
+	return validators, nil
+}
 
-	// now we're ready to calculate the nakomoto coefficient
-	nakamotoCoefficient := utils.CalcNakamotoCoefficient(totalVotingPower, votingPowers)
-	fmt.Println("The Nakamoto coefficient for BNB Smart Chain is", nakamotoCoefficient)
+// bscActiveSet returns the lowercase consensus addresses of the current active set.
+func bscActiveSet(ctx context.Context, deps Deps, rpcURL string) (map[string]bool, error) {
+	var res string
+	call := map[string]string{"to": bscValidatorSetContract, "data": abi.EncodeCall(selectorBscGetValidators)}
+	if err := deps.RPC(rpcURL).Call(ctx, "eth_call", []interface{}{call, "latest"}, &res); err != nil {
+		return nil, err
+	}
+
+	data, err := abi.Decode(res)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := data.AddressArray(0)
+	if err != nil {
+		return nil, fmt.Errorf("getValidators: %w", err)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("getValidators returned no validators")
+	}
+
+	active := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		active[addr] = true
+	}
+
+	return active, nil
+}
+
+// bscConsensusAddresses returns the lowercase consensus address of each validator, in order,
+// with batched StakeHub calls of "bsc_batch_size" requests.
+func bscConsensusAddresses(ctx context.Context, deps Deps, rpcURL string, validators []BscValidator) ([]string, error) {
+	batchSize := deps.SettingInt("bsc_batch_size", defaultBscConsensusBatchSize)
+	if batchSize < 1 {
+		return nil, fmt.Errorf("bsc batch size must be positive")
+	}
+
+	addrs := make([]string, 0, len(validators))
+	for start := 0; start < len(validators); start += batchSize {
+		end := start + batchSize
+		if end > len(validators) {
+			end = len(validators)
+		}
+
+		requests := make([]jsonrpc.Request, 0, end-start)
+		for _, v := range validators[start:end] {
+			operator, ok := new(big.Int).SetString(strings.TrimPrefix(v.OperatorAddress, "0x"), 16)
+			if !ok {
+				return nil, fmt.Errorf("validator %s: invalid operator address", v.OperatorAddress)
+			}
+
+			call := map[string]string{"to": bscStakeHubContract, "data": abi.EncodeCall(selectorBscGetConsensusAddress, operator)}
+			requests = append(requests, jsonrpc.Request{Method: "eth_call", Params: []interface{}{call, "latest"}})
+		}
+
+		responses, err := deps.RPC(rpcURL).Batch(ctx, requests)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, resp := range responses {
+			operator := validators[start+i].OperatorAddress
+
+			var res string
+			if err := resp.Decode(&res); err != nil {
+				return nil, fmt.Errorf("validator %s: %w", operator, err)
+			}
+
+			data, err := abi.Decode(res)
+			if err != nil {
+				return nil, fmt.Errorf("validator %s: %w", operator, err)
+			}
+
+			addr, err := data.Address(0)
+			if err != nil {
+				return nil, fmt.Errorf("validator %s: %w", operator, err)
+			}
+			addrs = append(addrs, addr)
+		}
+	}
 
-	return nakamotoCoefficient, nil
+	return addrs, nil
 }
 
 func weiToEther(wei *big.Int) int64 {
@@ -1,69 +1,539 @@
 package chains
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"log"
 	"math/big"
 	"net/http"
-	"sort"
+	"sync"
 
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/entities"
 	utils "github.com/xenowits/nakamoto-coefficient-calculator/core/utils"
 )
 
-type CardanoResponse struct {
-	Label string  `json:"label"`
-	Class string  `json:"class"`
-	Epoch int     `json:"epoch"`
-	Stake float64 `json:"stake"`
+const (
+	KoiosAPI      = "https://api.koios.rest/api/v1"
+	BlockfrostAPI = "https://cardano-mainnet.blockfrost.io/api/v0"
+
+	koiosPageSize             = 1000
+	blockfrostPageSize        = 100
+	defaultCardanoConcurrency = 8
+)
+
+// cardanoPool is the active stake of a stake pool in lovelace, along with the operator group
+// reported by the source, if any.
+type cardanoPool struct {
+	id    string
+	group string
+	stake *big.Int
 }
 
-func Cardano() (int, error) {
-	url := "https://www.balanceanalytics.io/api/mavdata.json"
+// cardanoSource reads the stake distribution of Cardano from an indexer API.
+type cardanoSource interface {
+	// epoch returns the current epoch.
+	epoch(ctx context.Context) (int, error)
+	// pools returns the registered and retiring pools with their active stake in the current epoch.
+	pools(ctx context.Context) ([]cardanoPool, error)
+	// retiredAfter returns the pools retired, or retiring, after the given epoch, which are missing
+	// from pools but may have had active stake in it.
+	retiredAfter(ctx context.Context, epoch int) ([]cardanoPool, error)
+	// epochStake returns the total active stake of the given epoch.
+	epochStake(ctx context.Context, epoch int) (*big.Int, error)
+	// poolStake returns the active stake of the pool in the given epoch.
+	poolStake(ctx context.Context, poolID string, epoch int) (*big.Int, error)
+}
 
-	req, err := http.NewRequest("GET", url, nil)
+// Cardano calculates the Nakamoto coefficient over the lovelace active stake of stake pools at the
+// 50% threshold, per pool and per operator, for the current and previous epoch.
+//
+// The "cardano_source" setting selects the data source, "koios" or "blockfrost". By default
+// Blockfrost is used when a project ID is configured and Koios otherwise. Pools are grouped into
+// operators by the "cardano_entities" mapping and, with Koios, by their pool group.
+//
+// The previous epoch is measured over the pools registered in it, including those retired since,
+// against the total active stake the source reports for it. It requires one request per pool,
+// made "cardano_concurrency" at a time, and is skipped when the "cardano_previous_epoch" setting
+// is "false". A past epoch never changes, so its pool stakes are measured once and reused by the
+// following refreshes until the epoch ends. Failing to measure it is logged and omits its metrics
+// rather than failing the report.
+func Cardano(ctx context.Context, deps Deps) (Report, error) {
+	source := deps.Setting("cardano_source", "")
+	if source == "" {
+		source = "koios"
+		if deps.APIKeys["blockfrost"] != "" {
+			source = "blockfrost"
+		}
+	}
+
+	var (
+		api     cardanoSource
+		baseURL string
+	)
+	switch source {
+	case "koios":
+		baseURL = deps.Endpoint("cardano", KoiosAPI)
+		api = koiosSource{deps: deps, baseURL: baseURL}
+	case "blockfrost":
+		if deps.APIKeys["blockfrost"] == "" {
+			return Report{}, fmt.Errorf("BLOCKFROST_PROJECT_ID is missing")
+		}
+		baseURL = deps.Endpoint("blockfrost", BlockfrostAPI)
+		api = blockfrostSource{deps: deps, baseURL: baseURL}
+	default:
+		return Report{}, fmt.Errorf("unknown cardano source %q", source)
+	}
+
+	mapping, err := entities.Load(ctx, deps, deps.Setting("cardano_entities", ""))
 	if err != nil {
-		log.Println("Error creating request:", err)
-		return 0, err
+		return Report{}, fmt.Errorf("failed to load entities for cardano: %w", err)
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	epoch, err := api.epoch(ctx)
 	if err != nil {
-		log.Println("Error making request:", err)
-		return 0, err
+		return Report{}, fmt.Errorf("cardano epoch: %w", err)
+	}
+
+	pools, err := api.pools(ctx)
+	if err != nil {
+		return Report{}, fmt.Errorf("cardano pools: %w", err)
+	}
+
+	perPool, perOperator, operators, err := cardanoCoefficients(pools, mapping, nil)
+	if err != nil {
+		return Report{}, fmt.Errorf("cardano epoch %d: %w", epoch, err)
+	}
+	deps.Logger.Printf("Cardano epoch %d: %d pools in %d operators", epoch, len(pools), operators)
+	deps.Logger.Println("The Nakamoto coefficient for Cardano is:", perPool)
+
+	report := Report{
+		Coefficient: perPool,
+		Metrics: []Metric{{
+			Name:        "operators",
+			Value:       perOperator,
+			Methodology: fmt.Sprintf("pools grouped into %d operators by address mappings and pool groups, active stake of epoch %d, 50%% threshold", operators, epoch),
+		}},
+	}
+
+	if deps.Setting("cardano_previous_epoch", "true") == "false" {
+		return report, nil
+	}
+
+	metrics, err := cardanoPreviousEpoch(ctx, deps, api, baseURL, pools, mapping, epoch-1)
+	if err != nil {
+		deps.Logger.Printf("Skipping Cardano epoch %d: %v", epoch-1, err)
+		return report, nil
+	}
+	report.Metrics = append(report.Metrics, metrics...)
+
+	return report, nil
+}
+
+// cardanoEpochCache holds the pool stakes of the last past epoch measured, keyed by the source
+// URL and epoch.
+var cardanoEpochCache struct {
+	sync.Mutex
+	key   string
+	pools []cardanoPool
+	total *big.Int
+}
+
+// cardanoPreviousEpoch returns the metrics of epoch, given the pools registered in the current
+// epoch. The pool stakes read from the source at baseURL are cached until epoch changes.
+func cardanoPreviousEpoch(ctx context.Context, deps Deps, api cardanoSource, baseURL string, pools []cardanoPool, mapping entities.Mapping, epoch int) ([]Metric, error) {
+	key := fmt.Sprintf("%s %d", baseURL, epoch)
+
+	cardanoEpochCache.Lock()
+	previous, total := cardanoEpochCache.pools, cardanoEpochCache.total
+	if cardanoEpochCache.key != key {
+		previous, total = nil, nil
+	}
+	cardanoEpochCache.Unlock()
+
+	if previous == nil {
+		var err error
+		previous, total, err = cardanoEpochPools(ctx, deps, api, pools, epoch)
+		if err != nil {
+			return nil, err
+		}
+
+		cardanoEpochCache.Lock()
+		cardanoEpochCache.key, cardanoEpochCache.pools, cardanoEpochCache.total = key, previous, total
+		cardanoEpochCache.Unlock()
+	} else {
+		deps.Logger.Printf("Reusing the active stake of %d Cardano pools measured for epoch %d", len(previous), epoch)
+	}
+
+	perPool, perOperator, operators, err := cardanoCoefficients(previous, mapping, total)
+	if err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	var responseData struct {
-		ApiData []CardanoResponse `json:"api_data"`
+	return []Metric{
+		{
+			Name:        "previous_epoch_pools",
+			Value:       perPool,
+			Methodology: fmt.Sprintf("pools counted independently, active stake of epoch %d, 50%% threshold", epoch),
+		},
+		{
+			Name:        "previous_epoch_operators",
+			Value:       perOperator,
+			Methodology: fmt.Sprintf("pools grouped into %d operators, active stake of epoch %d, 50%% threshold", operators, epoch),
+		},
+	}, nil
+}
+
+// cardanoEpochPools returns the active stake of the pools registered in epoch and the total active
+// stake of epoch, given the pools registered in the current epoch.
+func cardanoEpochPools(ctx context.Context, deps Deps, api cardanoSource, pools []cardanoPool, epoch int) ([]cardanoPool, *big.Int, error) {
+	total, err := api.epochStake(ctx, epoch)
+	if err != nil {
+		return nil, nil, fmt.Errorf("active stake: %w", err)
 	}
-	err = json.NewDecoder(resp.Body).Decode(&responseData)
+
+	retired, err := api.retiredAfter(ctx, epoch)
 	if err != nil {
-		log.Println("Error decoding JSON:", err)
+		return nil, nil, fmt.Errorf("retired pools: %w", err)
+	}
+
+	// A pool retired and registered again is listed by both.
+	registered := make(map[string]bool, len(pools))
+	for _, p := range pools {
+		registered[p.id] = true
+	}
+	candidates := append([]cardanoPool(nil), pools...)
+	for _, p := range retired {
+		if !registered[p.id] {
+			candidates = append(candidates, p)
+		}
+	}
+
+	previous, err := cardanoPreviousPools(ctx, deps, api, candidates, epoch)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return previous, total, nil
+}
+
+// cardanoCoefficients returns the coefficients over pools and over operators at the 50% threshold.
+// If total is not nil, it is the total active stake and must not be less than that of pools.
+func cardanoCoefficients(pools []cardanoPool, mapping entities.Mapping, total *big.Int) (perPool, perOperator, operators int, err error) {
+	var validators []entities.Validator
+	for _, p := range pools {
+		if p.stake.Sign() > 0 {
+			validators = append(validators, entities.Validator{Address: p.id, Identity: p.group, Stake: p.stake})
+		}
+	}
+	if len(validators) == 0 {
+		return 0, 0, 0, fmt.Errorf("no pools with active stake found")
+	}
+
+	poolPowers, operatorPowers := entities.Powers(validators, mapping)
+	totalVotingPower := utils.CalculateTotalVotingPowerBigNums(poolPowers)
+	if total != nil {
+		if total.Cmp(totalVotingPower) < 0 {
+			return 0, 0, 0, fmt.Errorf("active stake of pools %s exceeds the total %s", totalVotingPower, total)
+		}
+		// Stake of pools the source did not list still counts towards the threshold.
+		totalVotingPower = total
+	}
+
+	return utils.CalcNakamotoCoefficientBigNums51(totalVotingPower, poolPowers),
+		utils.CalcNakamotoCoefficientBigNums51(totalVotingPower, operatorPowers),
+		len(operatorPowers), nil
+}
+
+// cardanoPreviousPools returns the active stake of pools in epoch.
+func cardanoPreviousPools(ctx context.Context, deps Deps, api cardanoSource, pools []cardanoPool, epoch int) ([]cardanoPool, error) {
+	concurrency := deps.SettingInt("cardano_concurrency", defaultCardanoConcurrency)
+	if concurrency < 1 {
+		return nil, fmt.Errorf("cardano concurrency must be positive")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		previous = make([]cardanoPool, len(pools))
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		sem      = make(chan struct{}, concurrency)
+	)
+
+	for i, p := range pools {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, p cardanoPool) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			stake, err := api.poolStake(ctx, p.id, epoch)
+			if err != nil {
+				once.Do(func() {
+					firstErr = fmt.Errorf("pool %s: %w", p.id, err)
+					cancel()
+				})
+				return
+			}
+			previous[i] = cardanoPool{id: p.id, group: p.group, stake: stake}
+		}(i, p)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return previous, nil
+}
+
+// parseLovelace parses an amount of lovelace, treating a missing amount as zero.
+func parseLovelace(amount *string) (*big.Int, error) {
+	if amount == nil || *amount == "" {
+		return new(big.Int), nil
+	}
+
+	n, ok := new(big.Int).SetString(*amount, 10)
+	if !ok || n.Sign() < 0 {
+		return nil, fmt.Errorf("invalid stake %q", *amount)
+	}
+
+	return n, nil
+}
+
+// koiosSource reads from a Koios API, authenticating with the "koios" API key if set.
+type koiosSource struct {
+	deps    Deps
+	baseURL string
+}
+
+func (k koiosSource) get(ctx context.Context, path string, v interface{}) error {
+	header := make(http.Header)
+	if key := k.deps.APIKeys["koios"]; key != "" {
+		header.Set("Authorization", "Bearer "+key)
+	}
+
+	return getJSON(ctx, k.deps, k.baseURL+path, header, v)
+}
+
+func (k koiosSource) epoch(ctx context.Context) (int, error) {
+	var tip []struct {
+		EpochNo int `json:"epoch_no"`
+	}
+	if err := k.get(ctx, "/tip", &tip); err != nil {
+		return 0, err
+	}
+	if len(tip) == 0 {
+		return 0, fmt.Errorf("empty tip")
+	}
+
+	return tip[0].EpochNo, nil
+}
+
+func (k koiosSource) pools(ctx context.Context) ([]cardanoPool, error) {
+	var pools []cardanoPool
+	for offset := 0; ; offset += koiosPageSize {
+		var page []struct {
+			PoolID      string  `json:"pool_id_bech32"`
+			PoolGroup   *string `json:"pool_group"`
+			ActiveStake *string `json:"active_stake"`
+		}
+		path := fmt.Sprintf("/pool_list?select=pool_id_bech32,pool_group,active_stake&pool_status=neq.retired&offset=%d&limit=%d", offset, koiosPageSize)
+		if err := k.get(ctx, path, &page); err != nil {
+			return nil, err
+		}
+
+		for _, p := range page {
+			stake, err := parseLovelace(p.ActiveStake)
+			if err != nil {
+				return nil, fmt.Errorf("pool %s: %w", p.PoolID, err)
+			}
+
+			pool := cardanoPool{id: p.PoolID, stake: stake}
+			if p.PoolGroup != nil {
+				pool.group = *p.PoolGroup
+			}
+			pools = append(pools, pool)
+		}
+
+		if len(page) < koiosPageSize {
+			return pools, nil
+		}
+	}
+}
+
+func (k koiosSource) retiredAfter(ctx context.Context, epoch int) ([]cardanoPool, error) {
+	var pools []cardanoPool
+	for offset := 0; ; offset += koiosPageSize {
+		var page []struct {
+			PoolID    string  `json:"pool_id_bech32"`
+			PoolGroup *string `json:"pool_group"`
+		}
+		path := fmt.Sprintf("/pool_list?select=pool_id_bech32,pool_group&pool_status=eq.retired&retiring_epoch=gt.%d&offset=%d&limit=%d", epoch, offset, koiosPageSize)
+		if err := k.get(ctx, path, &page); err != nil {
+			return nil, err
+		}
+
+		for _, p := range page {
+			pool := cardanoPool{id: p.PoolID}
+			if p.PoolGroup != nil {
+				pool.group = *p.PoolGroup
+			}
+			pools = append(pools, pool)
+		}
+
+		if len(page) < koiosPageSize {
+			return pools, nil
+		}
+	}
+}
+
+func (k koiosSource) epochStake(ctx context.Context, epoch int) (*big.Int, error) {
+	var info []struct {
+		ActiveStake *string `json:"active_stake"`
+	}
+	if err := k.get(ctx, fmt.Sprintf("/epoch_info?_epoch_no=%d", epoch), &info); err != nil {
+		return nil, err
+	}
+	if len(info) == 0 || info[0].ActiveStake == nil {
+		return nil, fmt.Errorf("no active stake for epoch %d", epoch)
+	}
+
+	return parseLovelace(info[0].ActiveStake)
+}
+
+func (k koiosSource) poolStake(ctx context.Context, poolID string, epoch int) (*big.Int, error) {
+	var history []struct {
+		EpochNo     int     `json:"epoch_no"`
+		ActiveStake *string `json:"active_stake"`
+	}
+	if err := k.get(ctx, fmt.Sprintf("/pool_history?_pool_bech32=%s&_epoch_no=%d", poolID, epoch), &history); err != nil {
+		return nil, err
+	}
+
+	for _, h := range history {
+		if h.EpochNo == epoch {
+			return parseLovelace(h.ActiveStake)
+		}
+	}
+
+	// The pool had no active stake in epoch.
+	return new(big.Int), nil
+}
+
+// blockfrostSource reads from a Blockfrost API with the "blockfrost" project ID.
+type blockfrostSource struct {
+	deps    Deps
+	baseURL string
+}
+
+func (b blockfrostSource) get(ctx context.Context, path string, v interface{}) error {
+	header := make(http.Header)
+	header.Set("project_id", b.deps.APIKeys["blockfrost"])
+
+	return getJSON(ctx, b.deps, b.baseURL+path, header, v)
+}
+
+func (b blockfrostSource) epoch(ctx context.Context) (int, error) {
+	var latest struct {
+		Epoch int `json:"epoch"`
+	}
+	if err := b.get(ctx, "/epochs/latest", &latest); err != nil {
 		return 0, err
 	}
 
-	var votingPowers []big.Int
-	for _, data := range responseData.ApiData {
-		stakeInt := big.NewInt(int64(data.Stake))
-		votingPowers = append(votingPowers, *stakeInt)
+	return latest.Epoch, nil
+}
+
+func (b blockfrostSource) pools(ctx context.Context) ([]cardanoPool, error) {
+	var pools []cardanoPool
+	for page := 1; ; page++ {
+		var list []struct {
+			PoolID      string  `json:"pool_id"`
+			ActiveStake *string `json:"active_stake"`
+		}
+		if err := b.get(ctx, fmt.Sprintf("/pools/extended?count=%d&page=%d", blockfrostPageSize, page), &list); err != nil {
+			return nil, err
+		}
+
+		for _, p := range list {
+			stake, err := parseLovelace(p.ActiveStake)
+			if err != nil {
+				return nil, fmt.Errorf("pool %s: %w", p.PoolID, err)
+			}
+			pools = append(pools, cardanoPool{id: p.PoolID, stake: stake})
+		}
+
+		if len(list) < blockfrostPageSize {
+			return pools, nil
+		}
 	}
+}
 
-	// need to sort the powers in descending order since they are in random order
-	sort.Slice(votingPowers, func(i, j int) bool {
-		return votingPowers[i].Cmp(&votingPowers[j]) > 0
-	})
+func (b blockfrostSource) retiredAfter(ctx context.Context, epoch int) ([]cardanoPool, error) {
+	// Retirements are listed in the order they were announced, which is not that of their epoch.
+	var pools []cardanoPool
+	for page := 1; ; page++ {
+		var list []struct {
+			PoolID string `json:"pool_id"`
+			Epoch  int    `json:"epoch"`
+		}
+		if err := b.get(ctx, fmt.Sprintf("/pools/retired?count=%d&page=%d", blockfrostPageSize, page), &list); err != nil {
+			return nil, err
+		}
 
-	// Calculate total voting power
-	totalVotingPower := utils.CalculateTotalVotingPowerBigNums(votingPowers)
+		for _, p := range list {
+			if p.Epoch > epoch {
+				pools = append(pools, cardanoPool{id: p.PoolID})
+			}
+		}
 
-	// Calculate Nakamoto coefficient
-	nakamotoCoefficient := utils.CalcNakamotoCoefficientBigNums51(totalVotingPower, votingPowers)
+		if len(list) < blockfrostPageSize {
+			return pools, nil
+		}
+	}
+}
 
-	fmt.Println("The total voting power for Cardano is: ", totalVotingPower)
-	fmt.Println("The Nakamoto coefficient for Cardano is: ", nakamotoCoefficient)
+func (b blockfrostSource) epochStake(ctx context.Context, epoch int) (*big.Int, error) {
+	var info struct {
+		ActiveStake *string `json:"active_stake"`
+	}
+	if err := b.get(ctx, fmt.Sprintf("/epochs/%d", epoch), &info); err != nil {
+		return nil, err
+	}
+	if info.ActiveStake == nil {
+		return nil, fmt.Errorf("no active stake for epoch %d", epoch)
+	}
+
+	return parseLovelace(info.ActiveStake)
+}
+
+func (b blockfrostSource) poolStake(ctx context.Context, poolID string, epoch int) (*big.Int, error) {
+	// The history is ordered by epoch, newest first, so the previous epoch is on the first page.
+	var history []struct {
+		Epoch       int     `json:"epoch"`
+		ActiveStake *string `json:"active_stake"`
+	}
+	if err := b.get(ctx, fmt.Sprintf("/pools/%s/history?order=desc&count=%d", poolID, blockfrostPageSize), &history); err != nil {
+		return nil, err
+	}
+
+	for _, h := range history {
+		if h.Epoch == epoch {
+			return parseLovelace(h.ActiveStake)
+		}
+	}
 
-	// Return Nakamoto coefficient
-	return nakamotoCoefficient, nil
+	return new(big.Int), nil
 }
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 	"time"
 )
 
@@ -16,18 +17,36 @@ type celestiaResp struct {
 	VotingPowerPercent float64 `json:"votingPowerPercent"`
 }
 
-func Celestia() (int, error) {
-	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancelFunc()
+// Celestia calculates the Nakamoto coefficient over the bonded tokens of Celestia validators
+// through the Cosmos SDK REST API. If the REST API fails, the voting power percentages published
+// by explorers.guru are used instead.
+func Celestia(ctx context.Context, deps Deps) (Report, error) {
+	baseURL := deps.Endpoint("celestia", "https://celestia-rest.publicnode.com")
+	validatorsURL := baseURL + "/cosmos/staking/v1beta1/validators?pagination.limit=500&status=BOND_STATUS_BONDED"
+	stakingPoolURL := baseURL + "/cosmos/staking/v1beta1/pool"
 
-	url := "https://celestia.api.explorers.guru/api/v1/validators"
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	report, err := FetchCosmosSDKNakaCoeff(ctx, deps, "celestia", validatorsURL, stakingPoolURL)
+	if err == nil {
+		return report, nil
+	}
+	deps.Logger.Printf("Celestia REST API failed, falling back to explorers.guru: %v", err)
 
-	if err != nil {
-		return 0, err
+	nakamotoCoefficient, fallbackErr := celestiaExplorersGuru(ctx, deps)
+	if fallbackErr != nil {
+		return Report{}, fmt.Errorf("%v; fallback: %w", err, fallbackErr)
 	}
 
-	resp, err := new(http.Client).Do(req)
+	return Report{Coefficient: nakamotoCoefficient}, nil
+}
+
+// celestiaExplorersGuru calculates the coefficient from the voting power percentages of
+// non-jailed validators published by explorers.guru.
+func celestiaExplorersGuru(ctx context.Context, deps Deps) (int, error) {
+	ctx, cancelFunc := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelFunc()
+
+	url := deps.Endpoint("celestia_explorer", "https://celestia.api.explorers.guru") + "/api/v1/validators"
+	resp, err := deps.Get(ctx, url)
 	if err != nil {
 		return 0, err
 	}
@@ -37,6 +56,9 @@ func Celestia() (int, error) {
 	if err != nil {
 		return 0, err
 	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("explorers.guru returned status %d", resp.StatusCode)
+	}
 
 	var response []celestiaResp
 	err = json.Unmarshal(body, &response)
@@ -44,19 +66,40 @@ func Celestia() (int, error) {
 		return 0, err
 	}
 
+	var (
+		votingPowers     []float64
+		totalVotingPower float64
+	)
+	for _, v := range response {
+		if v.Jailed || v.VotingPowerPercent <= 0 {
+			continue
+		}
+		votingPowers = append(votingPowers, v.VotingPowerPercent)
+		totalVotingPower += v.VotingPowerPercent
+	}
+	if len(votingPowers) == 0 {
+		return 0, fmt.Errorf("no validators with voting power found")
+	}
+
+	sort.Sort(sort.Reverse(sort.Float64Slice(votingPowers)))
+
+	// Percentages are relative to all validators, jailed ones included, so compare against the
+	// share of the remaining voting power.
+	threshold := totalVotingPower * nakamotoThreshold / 100
+
 	var (
 		cumulativePower     float64
 		nakamotoCoefficient int
 	)
-	for _, resp := range response {
-		cumulativePower += resp.VotingPowerPercent
+	for _, power := range votingPowers {
+		cumulativePower += power
 		nakamotoCoefficient += 1
-		if cumulativePower > nakamotoThreshold {
+		if cumulativePower > threshold {
 			break
 		}
 	}
 
-	fmt.Printf("The Nakamoto coefficient for %s is %d\n", "celestia", nakamotoCoefficient)
+	deps.Logger.Printf("The Nakamoto coefficient for %s is %d", "celestia", nakamotoCoefficient)
 
 	return nakamotoCoefficient, nil
 }
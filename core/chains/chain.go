@@ -1,14 +1,57 @@
 package chains
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"time"
+
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/entities"
 )
 
 // Chain contains details of a particular Chain.
 type Chain struct {
-	PrevNCVal int
-	CurrNCVal int
+	PrevNCVal int       `json:"prev_nc_val"`
+	CurrNCVal int       `json:"curr_nc_val"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Metrics   []Metric  `json:"metrics,omitempty"`
+	// Epoch is the epoch of the chain the values were calculated for, if the provider reports one.
+	Epoch uint64 `json:"epoch,omitempty"`
+	// Round is the round of the chain the values were calculated at, if the provider reports one.
+	Round uint64 `json:"round,omitempty"`
+	// Stale is set when the last refresh of the chain failed and the values are carried over from an
+	// earlier one, UpdatedAt being when they were calculated.
+	Stale bool `json:"stale,omitempty"`
+}
+
+// Metric is a supplementary coefficient reported alongside the headline value of a chain,
+// for example one control dimension of a rollup.
+type Metric struct {
+	Name        string `json:"name"`
+	Value       int    `json:"value"`
+	Methodology string `json:"methodology,omitempty"`
+}
+
+// Report is the result of providers that report more than the headline coefficient.
+type Report struct {
+	Coefficient int
+	Metrics     []Metric
+	// Epoch optionally tags the report with the epoch of the chain it was calculated for, so that
+	// the previous value is that of the previous epoch rather than of the previous refresh.
+	Epoch uint64
+	// Round optionally records the round of the chain the report was calculated at.
+	Round uint64
+}
+
+// entityReport reports the coefficient over validators as the headline value, alongside the
+// coefficients over validators and over the entities operating them.
+func entityReport(c entities.Coefficients) Report {
+	return Report{
+		Coefficient: c.Validators,
+		Metrics: []Metric{
+			{Name: "validators", Value: c.Validators, Methodology: "each validator counted independently, 33% threshold"},
+			{Name: "entities", Value: c.Entities, Methodology: fmt.Sprintf("validators grouped into %d entities by address mappings and operator identities, 33%% threshold", c.EntityCount)},
+		},
+	}
 }
 
 // Token represents the name of token for a blockchain.
@@ -39,7 +82,7 @@ const (
 	JUNO  Token = "JUNO"
 	MATIC Token = "MATIC"
 	MINA  Token = "MINA"
-	MON	  Token = "MON"
+	MON   Token = "MON"
 	NAM   Token = "NAM"
 	NEAR  Token = "NEAR"
 	OSMO  Token = "OSMO"
@@ -133,126 +176,163 @@ func (t Token) ChainName() string {
 var Tokens = []Token{ADA, ALGO, APT, ATOM, AVAIL, AVAX, BASE, BLD, BNB, DOT, EGLD, ETH, GRT, HBAR, HYPE, JUNO, MATIC, MINA, MON, NAM, NEAR, OSMO, PLS, PLUME, REGEN, RUNE, SEI, SOL, STARS, STORY, SUI, TIA, XNO}
 
 // NewState returns a new fresh state.
-func NewState() ChainState {
+func NewState(ctx context.Context, deps Deps) ChainState {
 	state := make(ChainState)
 
-	return RefreshChainState(state)
+	return RefreshChainState(ctx, deps, state, nil)
 }
 
-func RefreshChainState(prevState ChainState) ChainState {
+// RefreshChainState recalculates the coefficients of all chains, carrying over the current values
+// of prevState as previous values. Chains that fail to update, or are not reached before ctx is
+// cancelled, keep their entry of prevState marked as stale, so a transient error never drops the
+// last good value.
+// If onUpdate is not nil, it is called with each chain as soon as its new value is known.
+func RefreshChainState(ctx context.Context, deps Deps, prevState ChainState, onUpdate func(Token, Chain)) ChainState {
+	start := deps.Clock()
 	newState := make(ChainState)
 	for _, token := range Tokens {
-		currVal, err := newValues(token)
+		report, err := newValues(ctx, deps, token)
+		if ctx.Err() != nil {
+			// Providers that skip failed requests may return partial results once cancelled.
+			deps.Logger.Println("Refresh cancelled:", ctx.Err())
+			break
+		}
 		if err != nil {
-			log.Println("Failed to update chain info:", token, err)
+			deps.Logger.Println("Failed to update chain info:", token, err)
 			continue
 		}
 
-		newState[token] = Chain{
-			PrevNCVal: prevState[token].CurrNCVal,
-			CurrNCVal: currVal,
+		prev := prevState[token]
+		chain := Chain{
+			PrevNCVal: prev.CurrNCVal,
+			CurrNCVal: report.Coefficient,
+			UpdatedAt: deps.Clock(),
+			Metrics:   report.Metrics,
+			Epoch:     report.Epoch,
+			Round:     report.Round,
+		}
+		if report.Epoch != 0 && report.Epoch == prev.Epoch {
+			// Still in the epoch of the last refresh, so keep comparing against the epoch before it.
+			chain.PrevNCVal = prev.PrevNCVal
+		}
+
+		newState[token] = chain
+		if onUpdate != nil {
+			onUpdate(token, newState[token])
+		}
+	}
+
+	deps.Logger.Printf("Refreshed %d of %d chains in %s", len(newState), len(Tokens), deps.Clock().Sub(start).Round(time.Second))
+
+	for _, token := range Tokens {
+		if _, ok := newState[token]; ok {
+			continue
+		}
+		if prev, ok := prevState[token]; ok {
+			prev.Stale = true
+			newState[token] = prev
 		}
 	}
 
 	return newState
 }
 
-func newValues(token Token) (int, error) {
+func newValues(ctx context.Context, deps Deps, token Token) (Report, error) {
 	var (
-		currVal int
-		err     error
+		report Report
+		err    error
 	)
 
-	log.Printf("Calculating Nakamoto coefficient for %s", token.ChainName())
+	deps.Logger.Printf("Calculating Nakamoto coefficient for %s", token.ChainName())
 
 	switch token {
 	case ADA:
-		currVal, err = Cardano()
+		report, err = Cardano(ctx, deps)
 	case ALGO:
-		currVal, err = Algorand()
+		report, err = Algorand(ctx, deps)
 	case APT:
-		currVal, err = Aptos()
+		report, err = Aptos(ctx, deps)
 	case ATOM:
-		currVal, err = Cosmos()
+		report, err = Cosmos(ctx, deps)
 	case AVAIL:
-		currVal, err = Avail()
+		report, err = Avail(ctx, deps)
 	case AVAX:
-		currVal, err = Avalanche()
+		report, err = Avalanche(ctx, deps)
 	case BASE:
-		currVal, err = Base()
+		report, err = Base(ctx, deps)
 	case BLD:
-		currVal, err = Agoric()
+		report, err = Agoric(ctx, deps)
 	case BNB:
-		currVal, err = BSC()
+		report, err = BSC(ctx, deps)
 	case DOT:
-		currVal, err = Polkadot()
+		report, err = Polkadot(ctx, deps)
 	case EGLD:
-		currVal, err = MultiversX()
+		report, err = MultiversX(ctx, deps)
 	case ETH:
-		currVal, err = Ethereum()
+		report.Coefficient, err = Ethereum(ctx, deps)
 	case GRT:
-		currVal, err = Graph()
+		report, err = Graph(ctx, deps)
 	case HBAR:
-		currVal, err = Hedera()
+		report, err = Hedera(ctx, deps)
 	case HYPE:
-		currVal, err = Hyperliquid()
+		report.Coefficient, err = Hyperliquid(ctx, deps)
 	case JUNO:
-		currVal, err = Juno()
+		report, err = Juno(ctx, deps)
 	case MATIC:
-		currVal, err = Polygon()
+		report.Coefficient, err = Polygon(ctx, deps)
 	case MINA:
-		currVal, err = Mina()
+		report.Coefficient, err = Mina(ctx, deps)
 	case MON:
-		log.Println("Attempting to calculate Monad Nakamoto coefficient...")
-		currVal, err = Monad()
+		deps.Logger.Println("Attempting to calculate Monad Nakamoto coefficient...")
+		report.Coefficient, err = Monad(ctx, deps)
 		if err != nil {
-			log.Printf("Error calculating Monad Nakamoto coefficient: %v", err)
+			deps.Logger.Printf("Error calculating Monad Nakamoto coefficient: %v", err)
 		}
 	case NAM:
-		currVal, err = Namada()
+		report.Coefficient, err = Namada(ctx, deps)
 	case NEAR:
-		currVal, err = Near()
+		report, err = Near(ctx, deps)
 	case OSMO:
-		currVal, err = Osmosis()
+		report, err = Osmosis(ctx, deps)
 	case PLS:
-		currVal, err = Pulsechain()
+		report.Coefficient, err = Pulsechain(ctx, deps)
 	case PLUME:
-		currVal, err = Plume()
+		report, err = Plume(ctx, deps)
 	case REGEN:
-		currVal, err = Regen()
+		report, err = Regen(ctx, deps)
 	case RUNE:
-		currVal, err = Thorchain()
+		report, err = Thorchain(ctx, deps)
 	case SEI:
-		log.Println("Attempting to calculate Sei Nakamoto coefficient...")
-		currVal, err = Sei()
+		deps.Logger.Println("Attempting to calculate Sei Nakamoto coefficient...")
+		report, err = Sei(ctx, deps)
 		if err != nil {
-			log.Printf("Error calculating Sei Nakamoto coefficient: %v", err)
+			deps.Logger.Printf("Error calculating Sei Nakamoto coefficient: %v", err)
 		}
 	case SOL:
-		currVal, err = Solana()
+		report, err = Solana(ctx, deps)
 	case STARS:
-		log.Println("Attempting to calculate Stargaze Nakamoto coefficient...")
-		currVal, err = Stargaze()
+		deps.Logger.Println("Attempting to calculate Stargaze Nakamoto coefficient...")
+		report, err = Stargaze(ctx, deps)
 		if err != nil {
-			log.Printf("Error calculating Stargaze Nakamoto coefficient: %v", err)
+			deps.Logger.Printf("Error calculating Stargaze Nakamoto coefficient: %v", err)
 		}
 	case STORY:
-		currVal, err = Story()
+		report.Coefficient, err = Story(ctx, deps)
 	case SUI:
-		currVal, err = Sui()
+		report, err = Sui(ctx, deps)
 	case TIA:
-		currVal, err = Celestia()
+		report, err = Celestia(ctx, deps)
 	case XNO:
-		currVal, err = Nano()
+		report.Coefficient, err = Nano(ctx, deps)
 	default:
-		return 0, fmt.Errorf("chain not found: %s", token)
+		return Report{}, fmt.Errorf("chain not found: %s", token)
 	}
 
 	if err != nil {
-		log.Printf("Error in chain %s: %v", token.ChainName(), err)
+		deps.Logger.Printf("Error in chain %s: %v", token.ChainName(), err)
 	} else {
-		log.Printf("Successfully calculated Nakamoto coefficient for %s: %d", token.ChainName(), currVal)
+		deps.Logger.Printf("Successfully calculated Nakamoto coefficient for %s: %d", token.ChainName(), report.Coefficient)
 	}
 
-	return currVal, err
+	return report, err
 }
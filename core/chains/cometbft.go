@@ -0,0 +1,56 @@
+package chains
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+const cometBFTValidatorsPerPage = 100
+
+type CometBFTValidator struct {
+	Address     string `json:"address"`
+	VotingPower string `json:"voting_power"`
+}
+
+type CometBFTValidatorsResult struct {
+	BlockHeight string              `json:"block_height"`
+	Validators  []CometBFTValidator `json:"validators"`
+	Count       string              `json:"count"`
+	Total       string              `json:"total"`
+}
+
+// fetchCometBFTValidators pages through the validators method of the CometBFT JSON-RPC at url.
+func fetchCometBFTValidators(ctx context.Context, deps Deps, url string) ([]CometBFTValidator, error) {
+	var (
+		rpc             = deps.RPC(url)
+		allValidators   []CometBFTValidator
+		totalValidators int
+	)
+
+	for page := 1; ; page++ {
+		params := map[string]string{
+			"page":     strconv.Itoa(page),
+			"per_page": strconv.Itoa(cometBFTValidatorsPerPage),
+		}
+
+		var result CometBFTValidatorsResult
+		if err := rpc.Call(ctx, "validators", params, &result); err != nil {
+			return nil, fmt.Errorf("validators page %d: %w", page, err)
+		}
+
+		allValidators = append(allValidators, result.Validators...)
+
+		if totalValidators == 0 {
+			total, err := strconv.Atoi(result.Total)
+			if err != nil {
+				return nil, fmt.Errorf("validators page %d: invalid total %q", page, result.Total)
+			}
+			totalValidators = total
+		}
+
+		if len(allValidators) >= totalValidators || len(result.Validators) == 0 {
+			return allValidators, nil
+		}
+	}
+}
@@ -6,23 +6,21 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"math/big"
 	"net/http"
-	"sort"
-	"strconv"
 	"time"
 
-	utils "github.com/xenowits/nakamoto-coefficient-calculator/core/utils"
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/entities"
 )
 
 const BONDED = "BOND_STATUS_BONDED"
 
-func Cosmos() (int, error) {
-	validatorDataURL := "https://rest.cosmos.directory/cosmoshub/cosmos/staking/v1beta1/validators?pagination.limit=500&status=BOND_STATUS_BONDED"
-    stakingPoolURL := "https://rest.cosmos.directory/cosmoshub/cosmos/staking/v1beta1/pool"
+func Cosmos(ctx context.Context, deps Deps) (Report, error) {
+	baseURL := deps.Endpoint("cosmos", "https://rest.cosmos.directory/cosmoshub")
+	validatorDataURL := baseURL + "/cosmos/staking/v1beta1/validators?pagination.limit=500&status=BOND_STATUS_BONDED"
+	stakingPoolURL := baseURL + "/cosmos/staking/v1beta1/pool"
 
-	return FetchCosmosSDKNakaCoeff("cosmos", validatorDataURL, stakingPoolURL)
+	return FetchCosmosSDKNakaCoeff(ctx, deps, "cosmos", validatorDataURL, stakingPoolURL)
 }
 
 type cosmosValidatorData struct {
@@ -36,6 +34,10 @@ type cosmosValidatorData struct {
 		Status          string `json:"status"`
 		Tokens          string `json:"tokens"`
 		DelegatorShares string `json:"delegator_shares"`
+		Description     struct {
+			Moniker  string `json:"moniker"`
+			Identity string `json:"identity"`
+		} `json:"description"`
 	} `json:"validators"`
 }
 
@@ -46,82 +48,88 @@ type cosmosStakingPoolData struct {
 	} `json:"pool"`
 }
 
-// fetchCosmosSDKNakaCoeff returns the nakamoto coefficient for a given cosmos SDK-based chain through REST API.
-func FetchCosmosSDKNakaCoeff(chainName, validatorURL, poolURL string) (int, error) {
+// FetchCosmosSDKNakaCoeff returns the nakamoto coefficient for a given cosmos SDK-based chain through REST API.
+// Validators are grouped into entities by the mapping named by the "<chainName>_entities" setting and
+// by their Keybase identity, and the coefficient over entities is reported alongside.
+func FetchCosmosSDKNakaCoeff(ctx context.Context, deps Deps, chainName, validatorURL, poolURL string) (Report, error) {
 	var (
-		votingPowers []big.Int
-		validators   cosmosValidatorData
-		pool         cosmosStakingPoolData
-		err          error
+		stakes     []entities.Validator
+		validators cosmosValidatorData
+		pool       cosmosStakingPoolData
+		err        error
 	)
 
-	log.Printf("Fetching data for %s", chainName)
+	deps.Logger.Printf("Fetching data for %s", chainName)
+
+	mapping, err := entities.Load(ctx, deps, deps.Setting(chainName+"_entities", ""))
+	if err != nil {
+		return Report{}, fmt.Errorf("failed to load entities for %s: %w", chainName, err)
+	}
 
 	// Fetch the validator data
-	validators, err = fetchValidatorData(validatorURL)
+	validators, err = fetchValidatorData(ctx, deps, validatorURL)
 	if err != nil {
-		return 0, fmt.Errorf("failed to fetch validator data for %s: %w", chainName, err)
+		return Report{}, fmt.Errorf("failed to fetch validator data for %s: %w", chainName, err)
 	}
 
 	// Fetch the staking pool data to get the total bonded tokens
-	pool, err = fetchStakingPoolData(poolURL)
+	pool, err = fetchStakingPoolData(ctx, deps, poolURL)
 	if err != nil {
-		return 0, fmt.Errorf("failed to fetch pool data for %s: %w", chainName, err)
+		return Report{}, fmt.Errorf("failed to fetch pool data for %s: %w", chainName, err)
 	}
 
 	// Convert the bonded tokens from the pool response
 	totalVotingPower, ok := new(big.Int).SetString(pool.Pool.BondedTokens, 10)
 	if !ok {
-		return 0, errors.New("failed to convert bonded tokens to big.Int")
+		return Report{}, errors.New("failed to convert bonded tokens to big.Int")
 	}
 
 	// Loop through the validators' voting powers
 	for _, ele := range validators.Validators {
-		if ele.Status != BONDED {
+		if ele.Status != BONDED || ele.Jailed {
 			continue
 		}
 
-		val, err := strconv.Atoi(ele.Tokens)
-		if err != nil {
-			log.Printf("Error parsing token value for %s: %s", chainName, ele.Tokens)
+		val, ok := new(big.Int).SetString(ele.Tokens, 10)
+		if !ok {
+			deps.Logger.Printf("Error parsing token value for %s: %s", chainName, ele.Tokens)
 			continue
 		}
-		votingPowers = append(votingPowers, *big.NewInt(int64(val)))
+		stakes = append(stakes, entities.Validator{
+			Address:  ele.OperatorAddress,
+			Identity: ele.Description.Identity,
+			Stake:    val,
+		})
 	}
 
 	// Summarize voting powers for logging
-	log.Printf("Voting powers for %s: %d validators with a total voting power of %s", chainName, len(votingPowers), totalVotingPower.String())
+	deps.Logger.Printf("Voting powers for %s: %d validators with a total voting power of %s", chainName, len(stakes), totalVotingPower.String())
 
-	if len(votingPowers) == 0 {
-		return 0, fmt.Errorf("no valid voting powers found for %s", chainName)
+	if len(stakes) == 0 {
+		return Report{}, fmt.Errorf("no valid voting powers found for %s", chainName)
 	}
 
-	// Sort the powers in descending order since they may be in random order
-	sort.Slice(votingPowers, func(i, j int) bool {
-		return votingPowers[i].Cmp(&votingPowers[j]) > 0
-	})
-
 	// Calculate the Nakamoto coefficient
-	nakamotoCoefficient := utils.CalcNakamotoCoefficientBigNums(totalVotingPower, votingPowers)
-	log.Printf("The Nakamoto coefficient for %s is %d", chainName, nakamotoCoefficient)
+	coefficients := entities.Calculate(stakes, mapping, totalVotingPower)
+	deps.Logger.Printf("The Nakamoto coefficient for %s is %d, %d over %d entities", chainName, coefficients.Validators, coefficients.Entities, coefficients.EntityCount)
 
-	return nakamotoCoefficient, nil
+	return entityReport(coefficients), nil
 }
 
 // Fetches data on active validator set
-func fetchValidatorData(url string) (cosmosValidatorData, error) {
-	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
+func fetchValidatorData(ctx context.Context, deps Deps, url string) (cosmosValidatorData, error) {
+	ctx, cancelFunc := context.WithTimeout(ctx, 10*time.Second)
 	defer cancelFunc()
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		log.Println(err)
+		deps.Logger.Println(err)
 		return cosmosValidatorData{}, errors.New("create get request for cosmos validators")
 	}
 
-	resp, err := new(http.Client).Do(req)
+	resp, err := deps.HTTPClient.Do(req)
 	if err != nil {
-		log.Println(err)
+		deps.Logger.Println(err)
 		return cosmosValidatorData{}, errors.New("get request unsuccessful for cosmos validators")
 	}
 	defer resp.Body.Close()
@@ -141,19 +149,19 @@ func fetchValidatorData(url string) (cosmosValidatorData, error) {
 }
 
 // Fetches staking pool data incl bonded and not_bonded tokens
-func fetchStakingPoolData(url string) (cosmosStakingPoolData, error) {
-	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
+func fetchStakingPoolData(ctx context.Context, deps Deps, url string) (cosmosStakingPoolData, error) {
+	ctx, cancelFunc := context.WithTimeout(ctx, 10*time.Second)
 	defer cancelFunc()
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		log.Println(err)
+		deps.Logger.Println(err)
 		return cosmosStakingPoolData{}, errors.New("create get request for cosmos pool")
 	}
 
-	resp, err := new(http.Client).Do(req)
+	resp, err := deps.HTTPClient.Do(req)
 	if err != nil {
-		log.Println(err)
+		deps.Logger.Println(err)
 		return cosmosStakingPoolData{}, errors.New("get request unsuccessful for cosmos pool")
 	}
 	defer resp.Body.Close()
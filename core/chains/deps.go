@@ -0,0 +1,181 @@
+package chains
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/jsonrpc"
+)
+
+// EndpointEnvPrefix is the prefix of environment variables overriding provider endpoints.
+// For example, NC_ENDPOINT_COSMOS=http://localhost:8181/cosmoshub overrides the "cosmos" endpoint.
+const EndpointEnvPrefix = "NC_ENDPOINT_"
+
+// SettingEnvPrefix is the prefix of environment variables holding provider settings.
+// For example, NC_SETTING_CARDANO_SOURCE=blockfrost sets the "cardano_source" setting.
+const SettingEnvPrefix = "NC_SETTING_"
+
+// defaultHTTPTimeout bounds every request of the default HTTP client, so that a provider without a
+// deadline of its own cannot stall the refresh of all chains.
+const defaultHTTPTimeout = 60 * time.Second
+
+// Deps contains everything a chain provider needs from its environment.
+type Deps struct {
+	// HTTPClient is used for all outgoing requests.
+	HTTPClient *http.Client
+	// Endpoints overrides the default base URL of a provider endpoint, keyed by endpoint name.
+	Endpoints map[string]string
+	// APIKeys contains credentials for providers that require them, keyed by provider name.
+	APIKeys map[string]string
+	// Settings contains provider specific options such as contract addresses, keyed by setting name.
+	Settings map[string]string
+	// Logger receives progress and diagnostic messages.
+	Logger *log.Logger
+	// Clock returns the current time.
+	Clock func() time.Time
+}
+
+// DefaultDeps returns dependencies talking to the public endpoints with no credentials.
+func DefaultDeps() Deps {
+	return Deps{
+		HTTPClient: &http.Client{Timeout: defaultHTTPTimeout},
+		Endpoints:  make(map[string]string),
+		APIKeys:    make(map[string]string),
+		Settings:   make(map[string]string),
+		Logger:     log.Default(),
+		Clock:      time.Now,
+	}
+}
+
+// DepsFromEnv returns DefaultDeps with endpoints, settings and credentials read from the environment.
+func DepsFromEnv() Deps {
+	deps := DefaultDeps()
+
+	for _, kv := range os.Environ() {
+		key, val, ok := strings.Cut(kv, "=")
+		if !ok || val == "" {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(key, EndpointEnvPrefix):
+			name := strings.ToLower(strings.TrimPrefix(key, EndpointEnvPrefix))
+			deps.Endpoints[name] = strings.TrimSuffix(val, "/")
+		case strings.HasPrefix(key, SettingEnvPrefix):
+			name := strings.ToLower(strings.TrimPrefix(key, SettingEnvPrefix))
+			deps.Settings[name] = val
+		}
+	}
+
+	if key := os.Getenv("RATED_API_KEY"); key != "" {
+		deps.APIKeys["rated"] = key
+	}
+	if key := os.Getenv("BLOCKFROST_PROJECT_ID"); key != "" {
+		deps.APIKeys["blockfrost"] = key
+	}
+	if key := os.Getenv("KOIOS_API_KEY"); key != "" {
+		deps.APIKeys["koios"] = key
+	}
+	if key := os.Getenv("ALGOD_TOKEN"); key != "" {
+		deps.APIKeys["algod"] = key
+	}
+	if key := os.Getenv("GRAPH_API_KEY"); key != "" {
+		deps.APIKeys["graph"] = key
+	}
+
+	return deps
+}
+
+// Endpoint returns the configured base URL for the named endpoint, or fallback if none is configured.
+func (d Deps) Endpoint(name, fallback string) string {
+	if url, ok := d.Endpoints[name]; ok {
+		return url
+	}
+
+	return fallback
+}
+
+// Setting returns the named setting, or fallback if it is not set.
+func (d Deps) Setting(name, fallback string) string {
+	if val, ok := d.Settings[name]; ok {
+		return val
+	}
+
+	return fallback
+}
+
+// SettingInt returns the named setting as an integer, or fallback if it is not set or invalid.
+func (d Deps) SettingInt(name string, fallback int) int {
+	val, ok := d.Settings[name]
+	if !ok {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		d.Logger.Printf("Ignoring invalid setting %s=%q: %v", name, val, err)
+		return fallback
+	}
+
+	return n
+}
+
+// SettingList returns the named setting split on commas, or nil if it is not set.
+func (d Deps) SettingList(name string) []string {
+	var list []string
+	for _, item := range strings.Split(d.Settings[name], ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// RPC returns a JSON-RPC client for the endpoint at url using the HTTP client of d.
+func (d Deps) RPC(url string) *jsonrpc.Client {
+	return jsonrpc.New(d.HTTPClient, url)
+}
+
+// Get issues a GET request for url with ctx using the HTTP client of d.
+func (d Deps) Get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.HTTPClient.Do(req)
+}
+
+// getJSON decodes the JSON response of a GET request for url with header into v, returning an
+// error including the start of the body if the status is not 200 OK.
+func getJSON(ctx context.Context, deps Deps, url string, header http.Header, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	for k, vals := range header {
+		req.Header[k] = vals
+	}
+
+	resp, err := deps.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned status %d: %s", url, resp.StatusCode, body)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -1,12 +1,11 @@
 package chains
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
-	"os"
 	"sort"
 	"time"
 )
@@ -16,29 +15,53 @@ type RatedResponse struct {
 }
 
 type RatedOperator struct {
-	ID                 string  `json:"id"`          
-	NetworkPenetration float64 `json:"networkPenetration"` 
+	ID                 string  `json:"id"`
+	NetworkPenetration float64 `json:"networkPenetration"`
 	ValidatorCount     int     `json:"validatorCount"`
 }
 
-func Ethereum() (int, error) {
+// Ethereum calculates the Nakamoto coefficient of Ethereum staking operators. The "ethereum_source"
+// setting selects the data source, "rated" or "beacon". By default Rated is used when an API key is
+// configured and the beacon node otherwise.
+func Ethereum(ctx context.Context, deps Deps) (int, error) {
+	source := deps.Setting("ethereum_source", "")
+	if source == "" {
+		source = "beacon"
+		if deps.APIKeys["rated"] != "" {
+			source = "rated"
+		}
+	}
+
+	switch source {
+	case "rated":
+		return ethereumRated(ctx, deps)
+	case "beacon":
+		return ethereumBeacon(ctx, deps)
+	default:
+		return 0, fmt.Errorf("unknown ethereum source %q", source)
+	}
+}
+
+func ethereumRated(ctx context.Context, deps Deps) (int, error) {
 	// Rated Network API
-	url := "https://api.rated.network/v0/eth/operators?window=1d"
+	url := deps.Endpoint("rated", "https://api.rated.network") + "/v0/eth/operators?window=1d"
+
+	ctx, cancelFunc := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelFunc()
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return 0, err
 	}
 
-	apiKey := os.Getenv("RATED_API_KEY")
+	apiKey := deps.APIKeys["rated"]
 	if apiKey == "" {
 		return 0, fmt.Errorf("RATED_API_KEY is missing")
 	}
 	req.Header.Add("Authorization", "Bearer "+apiKey)
 	req.Header.Add("X-Rated-Network", "mainnet")
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := deps.HTTPClient.Do(req)
 	if err != nil {
 		return 0, err
 	}
@@ -70,7 +93,7 @@ func Ethereum() (int, error) {
 
 	for _, op := range operators {
 		sharePercent := op.NetworkPenetration * 100
-		
+
 		totalShare += sharePercent
 		nakamotoCoefficient++
 
@@ -79,6 +102,6 @@ func Ethereum() (int, error) {
 		}
 	}
 
-	log.Printf("The Nakamoto coefficient for Ethereum is %d", nakamotoCoefficient)
+	deps.Logger.Printf("The Nakamoto coefficient for Ethereum is %d", nakamotoCoefficient)
 	return nakamotoCoefficient, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,186 @@
+package chains
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/entities"
+	utils "github.com/xenowits/nakamoto-coefficient-calculator/core/utils"
+)
+
+const beaconValidatorsPath = "/eth/v1/beacon/states/head/validators?status=active"
+
+type BeaconValidator struct {
+	Index     string `json:"index"`
+	Status    string `json:"status"`
+	Validator struct {
+		Pubkey                string `json:"pubkey"`
+		WithdrawalCredentials string `json:"withdrawal_credentials"`
+		EffectiveBalance      string `json:"effective_balance"`
+	} `json:"validator"`
+}
+
+// ethereumBeacon groups the effective balance of active validators read from a beacon node into
+// operators. Validators are attributed using the label file named by the "ethereum_labels" setting,
+// a JSON object mapping validator pubkeys, withdrawal credentials, withdrawal addresses or deposit
+// addresses to operator names, for example {"0xb9d7934878b5fb9610b3fe8a5e441e8fad7e293f": "Lido"}.
+// Unlabelled validators sharing withdrawal credentials are assumed to belong to the same operator.
+//
+// The beacon node does not know which address deposited a validator, so deposit addresses are only
+// matched for the validators listed in the deposit index named by the "ethereum_deposits" setting,
+// a JSON object mapping validator pubkeys to the address that sent their deposit, as extracted from
+// the transactions of the deposit contract.
+func ethereumBeacon(ctx context.Context, deps Deps) (int, error) {
+	labels, err := entities.Load(ctx, deps, deps.Setting("ethereum_labels", ""))
+	if err != nil {
+		return 0, fmt.Errorf("ethereum labels: %w", err)
+	}
+	deposits, err := entities.Load(ctx, deps, deps.Setting("ethereum_deposits", ""))
+	if err != nil {
+		return 0, fmt.Errorf("ethereum deposits: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	url := deps.Endpoint("beacon", "http://localhost:5052") + beaconValidatorsPath
+	resp, err := deps.Get(ctx, url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("beacon node returned status %d", resp.StatusCode)
+	}
+
+	stakePerOperator := make(map[string]*big.Int)
+	matched := make(map[string]bool, len(labels))
+	labelled, validators := 0, 0
+	err = decodeBeaconValidators(resp.Body, func(v BeaconValidator) error {
+		if !strings.HasPrefix(v.Status, "active") {
+			return nil
+		}
+
+		balance, err := strconv.ParseUint(v.Validator.EffectiveBalance, 10, 64)
+		if err != nil {
+			return fmt.Errorf("validator %s: invalid effective balance %q", v.Index, v.Validator.EffectiveBalance)
+		}
+
+		operator, key, ok := beaconOperator(labels, deposits, v)
+		if ok {
+			labelled++
+			matched[key] = true
+		}
+		validators++
+
+		if stakePerOperator[operator] == nil {
+			stakePerOperator[operator] = new(big.Int)
+		}
+		stakePerOperator[operator].Add(stakePerOperator[operator], new(big.Int).SetUint64(balance))
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if validators == 0 {
+		return 0, fmt.Errorf("no active validators found")
+	}
+
+	var votingPowers []big.Int
+	for _, stake := range stakePerOperator {
+		votingPowers = append(votingPowers, *stake)
+	}
+
+	sort.Slice(votingPowers, func(i, j int) bool {
+		return (&votingPowers[i]).Cmp(&votingPowers[j]) > 0
+	})
+
+	deps.Logger.Printf("Attributed %d of %d active validators to labelled operators, %d operators in total", labelled, validators, len(votingPowers))
+	if unmatched := len(labels) - len(matched); unmatched > 0 {
+		deps.Logger.Printf("%d Ethereum labels matched no active validator; deposit addresses need the ethereum_deposits index (%d deposits indexed)",
+			unmatched, len(deposits))
+	}
+
+	totalVotingPower := utils.CalculateTotalVotingPowerBigNums(votingPowers)
+	nakamotoCoefficient := utils.CalcNakamotoCoefficientBigNums(totalVotingPower, votingPowers)
+	deps.Logger.Printf("The Nakamoto coefficient for Ethereum is %d", nakamotoCoefficient)
+
+	return nakamotoCoefficient, nil
+}
+
+// beaconOperator returns the labelled operator of v along with the label key it was found by, or its
+// withdrawal credentials if it is unlabelled. The pubkey takes precedence over the withdrawal
+// credentials and address, which take precedence over the deposit address.
+func beaconOperator(labels, deposits entities.Mapping, v BeaconValidator) (string, string, bool) {
+	pubkey := strings.ToLower(v.Validator.Pubkey)
+	creds := strings.ToLower(v.Validator.WithdrawalCredentials)
+
+	keys := []string{pubkey, creds}
+	// 0x01 and 0x02 credentials end in the 20 byte execution withdrawal address.
+	if len(creds) == 66 && (strings.HasPrefix(creds, "0x01") || strings.HasPrefix(creds, "0x02")) {
+		keys = append(keys, "0x"+creds[26:])
+	}
+	if depositor, ok := deposits.Entity(pubkey); ok {
+		keys = append(keys, strings.ToLower(depositor))
+	}
+
+	for _, key := range keys {
+		if operator, ok := labels.Entity(key); ok {
+			return operator, key, true
+		}
+	}
+
+	return creds, "", false
+}
+
+// decodeBeaconValidators streams the data array of a beacon validators response into fn,
+// since the full response for mainnet is hundreds of megabytes.
+func decodeBeaconValidators(r io.Reader, fn func(BeaconValidator) error) error {
+	dec := json.NewDecoder(r)
+
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("beacon response: %w", err)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("beacon response: %w", err)
+		}
+
+		if tok != "data" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return fmt.Errorf("beacon response: %w", err)
+			}
+			continue
+		}
+
+		if _, err := dec.Token(); err != nil {
+			return fmt.Errorf("beacon response: %w", err)
+		}
+		for dec.More() {
+			var v BeaconValidator
+			if err := dec.Decode(&v); err != nil {
+				return fmt.Errorf("beacon validator: %w", err)
+			}
+			if err := fn(v); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return fmt.Errorf("beacon response: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -2,96 +2,199 @@ package chains
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"io"
 	"math/big"
 	"net/http"
 	"sort"
+	"strings"
 
 	utils "github.com/xenowits/nakamoto-coefficient-calculator/core/utils"
 )
 
+const (
+	// GraphGatewayURL is the Graph Network subgraph on Arbitrum, served by the gateway of The Graph.
+	GraphGatewayURL = "https://gateway.thegraph.com/api/subgraphs/id/DZz4kDTdmzWLWsV373w2bSmoar3umKKH9y82SUKr5qmp"
+
+	graphPageSize = 1000
+	// graphMaxPages guards against a subgraph that keeps returning full pages.
+	graphMaxPages = 100
+)
+
+const graphIndexersQuery = `query indexers($first: Int!, $lastID: String!) {
+  indexers(first: $first, orderBy: id, orderDirection: asc, where: {id_gt: $lastID, stakedTokens_gt: "0"}) {
+    id
+    stakedTokens
+    delegatedTokens
+  }
+}`
+
+const graphNetworkQuery = `{ graphNetwork(id: "1") { delegationRatio } }`
+
+type GraphIndexer struct {
+	Id              string `json:"id"`
+	StakedTokens    string `json:"stakedTokens"`
+	DelegatedTokens string `json:"delegatedTokens"`
+}
+
 type GraphResponse struct {
 	Data struct {
-		Indexers []struct {
-			Id           string `json:"id"`
-			StakedTokens string `json:"stakedTokens"`
-		} `json:"indexers"`
+		Indexers []GraphIndexer `json:"indexers"`
 	} `json:"data"`
 }
 
-type GraphErrorResponse struct {
-	Id      int    `json:"id"`
-	Jsonrpc string `json:"jsonrpc"`
-	Error   string `json:"error"`
+type GraphNetworkResponse struct {
+	Data struct {
+		GraphNetwork struct {
+			DelegationRatio int64 `json:"delegationRatio"`
+		} `json:"graphNetwork"`
+	} `json:"data"`
 }
 
-func Graph() (int, error) {
-	votingPowers := make([]big.Int, 0, 1000)
+type GraphErrorResponse struct {
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
 
-	// Sometimes, the gateway URL doesn't work idk why
-	url := fmt.Sprintf("https://gateway.thegraph.com/network")
-	// url := fmt.Sprintf("https://api.thegraph.com/subgraphs/name/graphprotocol/graph-network-mainnet")
-	jsonReqData := []byte(`{"query":"{ indexers (first: 1000) { id stakedTokens } }","variables":{}}`)
+// Graph calculates the Nakamoto coefficient over the allocation capacity of indexers: their own
+// stake plus the tokens delegated to them, the latter capped at the delegation ratio times their own
+// stake, as delegation beyond it cannot be allocated. The coefficient over the own stake of indexers
+// alone is reported as the "self_stake" metric.
+//
+// The Graph Network subgraph is queried through the gateway at the "graph" endpoint, authenticated
+// with the "graph" API key when set.
+func Graph(ctx context.Context, deps Deps) (Report, error) {
+	url := deps.Endpoint("graph", GraphGatewayURL)
+
+	var network GraphNetworkResponse
+	if err := graphQuery(ctx, deps, url, graphNetworkQuery, nil, &network); err != nil {
+		return Report{}, fmt.Errorf("graph network: %w", err)
+	}
+	delegationRatio := big.NewInt(network.Data.GraphNetwork.DelegationRatio)
 
-	// Create a new request using http
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonReqData))
+	indexers, err := fetchGraphIndexers(ctx, deps, url)
 	if err != nil {
-		return 0, err
+		return Report{}, err
 	}
-	req.Header.Add("Content-Type", "application/json")
 
-	// Send req using http Client
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	votingPowers := make([]big.Int, 0, len(indexers))
+	selfStakes := make([]big.Int, 0, len(indexers))
+	for _, ele := range indexers {
+		staked, ok := new(big.Int).SetString(ele.StakedTokens, 10)
+		if !ok {
+			return Report{}, fmt.Errorf("indexer %s: invalid staked tokens %q", ele.Id, ele.StakedTokens)
+		}
+		delegated, ok := new(big.Int).SetString(ele.DelegatedTokens, 10)
+		if !ok {
+			if ele.DelegatedTokens != "" {
+				return Report{}, fmt.Errorf("indexer %s: invalid delegated tokens %q", ele.Id, ele.DelegatedTokens)
+			}
+			delegated = new(big.Int)
+		}
+
+		// A delegation ratio of zero means delegation is not capped.
+		if delegationRatio.Sign() > 0 {
+			if capacity := new(big.Int).Mul(staked, delegationRatio); delegated.Cmp(capacity) > 0 {
+				delegated = capacity
+			}
+		}
+
+		votingPowers = append(votingPowers, *new(big.Int).Add(staked, delegated))
+		selfStakes = append(selfStakes, *staked)
+	}
+	if len(votingPowers) == 0 {
+		return Report{}, fmt.Errorf("no indexers with stake found")
+	}
+
+	// need to sort the powers in descending order since they are in random order
+	for _, powers := range [][]big.Int{votingPowers, selfStakes} {
+		sort.Slice(powers, func(i, j int) bool {
+			return (&powers[i]).Cmp(&powers[j]) > 0
+		})
+	}
+
+	totalVotingPower := utils.CalculateTotalVotingPowerBigNums(votingPowers)
+	deps.Logger.Printf("Total voting power of %d indexers with a delegation ratio of %s: %s", len(votingPowers), delegationRatio, totalVotingPower)
+
+	// now we're ready to calculate the nakomoto coefficient
+	nakamotoCoefficient := utils.CalcNakamotoCoefficientBigNums(totalVotingPower, votingPowers)
+	deps.Logger.Println("The Nakamoto coefficient for graph protocol is", nakamotoCoefficient)
+
+	return Report{
+		Coefficient: nakamotoCoefficient,
+		Metrics: []Metric{{
+			Name:        "self_stake",
+			Value:       utils.CalcNakamotoCoefficientBigNums(utils.CalculateTotalVotingPowerBigNums(selfStakes), selfStakes),
+			Methodology: fmt.Sprintf("own stake of %d indexers, excluding delegation, 33%% threshold", len(selfStakes)),
+		}},
+	}, nil
+}
+
+// fetchGraphIndexers returns all indexers with stake, paging by id.
+func fetchGraphIndexers(ctx context.Context, deps Deps, url string) ([]GraphIndexer, error) {
+	var indexers []GraphIndexer
+	lastID := ""
+	for page := 0; page < graphMaxPages; page++ {
+		var response GraphResponse
+		variables := map[string]interface{}{"first": graphPageSize, "lastID": lastID}
+		if err := graphQuery(ctx, deps, url, graphIndexersQuery, variables, &response); err != nil {
+			return nil, fmt.Errorf("graph indexers page %d: %w", page, err)
+		}
+		indexers = append(indexers, response.Data.Indexers...)
 
+		if len(response.Data.Indexers) < graphPageSize {
+			return indexers, nil
+		}
+		lastID = response.Data.Indexers[len(response.Data.Indexers)-1].Id
+	}
+
+	return nil, fmt.Errorf("graph indexers still returned a full page after %d pages", graphMaxPages)
+}
+
+// graphQuery posts a GraphQL query to url and decodes the response into result, returning the
+// GraphQL errors of the response, if any.
+func graphQuery(ctx context.Context, deps Deps, url, query string, variables map[string]interface{}, result interface{}) error {
+	jsonReqData, err := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
 	if err != nil {
-		errBody, _ := ioutil.ReadAll(resp.Body)
-		var errResp GraphErrorResponse
-		json.Unmarshal(errBody, &errResp)
-		log.Println(errResp.Error)
-		return 0, err
+		return err
 	}
-	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	// Create a new request using http
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jsonReqData))
 	if err != nil {
-		return 0, err
+		return err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	if key := deps.APIKeys["graph"]; key != "" {
+		req.Header.Add("Authorization", "Bearer "+key)
 	}
 
-	var response GraphResponse
-	err = json.Unmarshal(body, &response)
+	// Send req using http Client
+	resp, err := deps.HTTPClient.Do(req)
 	if err != nil {
-		return 0, err
+		return err
 	}
+	defer resp.Body.Close()
 
-	// loop through the validators voting powers
-	for _, ele := range response.Data.Indexers {
-		n, ok := new(big.Int).SetString(ele.StakedTokens, 10)
-		if !ok {
-			log.Fatalln("Couldn't parse string", ele.StakedTokens)
-		} else {
-			votingPowers = append(votingPowers, *n)
-		}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
 	}
 
-	// need to sort the powers in descending order since they are in random order
-	sort.Slice(votingPowers, func(i, j int) bool {
-		res := (&votingPowers[i]).Cmp(&votingPowers[j])
-		if res == 1 {
-			return true
+	var errResp GraphErrorResponse
+	if json.Unmarshal(body, &errResp) == nil && len(errResp.Errors) > 0 {
+		messages := make([]string, 0, len(errResp.Errors))
+		for _, e := range errResp.Errors {
+			messages = append(messages, e.Message)
 		}
-		return false
-	})
-
-	totalVotingPower := utils.CalculateTotalVotingPowerBigNums(votingPowers)
-	fmt.Println("Total voting power:", totalVotingPower)
-
-	// now we're ready to calculate the nakomoto coefficient
-	nakamotoCoefficient := utils.CalcNakamotoCoefficientBigNums(totalVotingPower, votingPowers)
-	fmt.Println("The Nakamoto coefficient for graph protocol is", nakamotoCoefficient)
+		return fmt.Errorf("graphql errors: %s", strings.Join(messages, "; "))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("graph gateway returned status %d", resp.StatusCode)
+	}
 
-	return nakamotoCoefficient, nil
+	return json.Unmarshal(body, result)
 }
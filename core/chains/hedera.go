@@ -1,91 +1,199 @@
 package chains
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"math/big"
 	"net/http"
-	"sort"
+	"strings"
 
-	utils "github.com/xenowits/nakamoto-coefficient-calculator/core/utils"
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/entities"
 )
 
 const TinyToHbar = 100_000_000 // Tinybar to Hbar.
 
-type Node []struct {
-	Description     string `json:"description"`
-	Node_Account    string `json:"node_account_id"`
-	Stake           int64  `json:"stake"`
+type HederaNode struct {
+	Description      string      `json:"description"`
+	Node_Account     string      `json:"node_account_id"`
+	Stake            json.Number `json:"stake"`
+	StakeRewarded    json.Number `json:"stake_rewarded"`
+	StakeNotRewarded json.Number `json:"stake_not_rewarded"`
+	MinStake         json.Number `json:"min_stake"`
+	MaxStake         json.Number `json:"max_stake"`
 }
 
-type Link struct{
-	Next	string `json:"next"`
+type Node []HederaNode
+
+type Link struct {
+	Next string `json:"next"`
 }
 
 type HederaResponse struct {
-	Nodes	Node
-	Links	Link
+	Nodes Node
+	Links Link
 }
 
-func Hedera() (int, error){
+// Hedera calculates the Nakamoto coefficient over the consensus weight of Hedera nodes read from a
+// mirror node. The consensus weight of a node is the sum of its rewarded and non-rewarded stake,
+// capped at its maximum stake, and zero below its minimum stake.
+//
+// Nodes are grouped by the council member organization hosting them, parsed from their description
+// ("Hosted by <organization> | <location>"), and the coefficient over organizations is reported as
+// the "organizations" metric. The "hedera_entities" mapping of node accounts takes precedence.
+func Hedera(ctx context.Context, deps Deps) (Report, error) {
 	// Set base url for requests.
-	var baseURL = "https://mainnet-public.mirrornode.hedera.com"
-	var query = "/api/v1/network/nodes"
+	var baseURL = deps.Endpoint("hedera", "https://mainnet-public.mirrornode.hedera.com")
 
-	// Declare variable for tracking votes for each node.
-	var votingPowers []big.Int
+	mapping, err := entities.Load(ctx, deps, deps.Setting("hedera_entities", ""))
+	if err != nil {
+		return Report{}, fmt.Errorf("failed to load entities for hedera: %w", err)
+	}
 
-	// Declare variables for tracking pagination.
-	var page = ""
+	nodes, err := fetchHederaNodes(ctx, deps, baseURL)
+	if err != nil {
+		return Report{}, err
+	}
 
-	// Loop over api responses for all pages.
-	for {
-		// Get response from API.
-		resp, err := http.Get(fmt.Sprintf("%s%s", baseURL, query))
+	var (
+		validators  []entities.Validator
+		rewarded    = new(big.Int)
+		notRewarded = new(big.Int)
+		totalWeight = new(big.Int)
+	)
+	for _, node := range nodes {
+		weight, nodeRewarded, nodeNotRewarded, err := hederaConsensusWeight(node)
 		if err != nil {
-			fmt.Println(err)
-			return 0, err
+			return Report{}, fmt.Errorf("node %s: %w", node.Node_Account, err)
 		}
-		defer resp.Body.Close()
+		rewarded.Add(rewarded, nodeRewarded)
+		notRewarded.Add(notRewarded, nodeNotRewarded)
 
-		// Decode json response to go objects.
-		var response HederaResponse
-		err = json.NewDecoder(resp.Body).Decode(&response)
-		if err != nil {
-			fmt.Println(err)
-			return 0, err
+		if weight.Sign() == 0 {
+			continue
 		}
-		
-		// Append node votes to array (from response).
-		for _, node := range response.Nodes {
-			votingPowers = append(votingPowers, *big.NewInt(node.Stake/TinyToHbar)) // Convert tinybar to hbar.
+		totalWeight.Add(totalWeight, weight)
+		validators = append(validators, entities.Validator{
+			Address:  node.Node_Account,
+			Identity: hederaOrganization(node.Description),
+			Stake:    weight,
+		})
+	}
+	if len(validators) == 0 {
+		return Report{}, fmt.Errorf("no nodes with consensus weight found among %d nodes", len(nodes))
+	}
+
+	deps.Logger.Printf("Hedera: %d nodes with a consensus weight of %s HBAR, %s HBAR rewarded and %s HBAR not rewarded",
+		len(validators), new(big.Int).Div(totalWeight, big.NewInt(TinyToHbar)),
+		new(big.Int).Div(rewarded, big.NewInt(TinyToHbar)), new(big.Int).Div(notRewarded, big.NewInt(TinyToHbar)))
+
+	// Now we're ready to calculate the nakomoto coefficient.
+	coefficients := entities.Calculate(validators, mapping, nil)
+	deps.Logger.Println("The Nakamoto coefficient for Hedera is", coefficients.Validators)
+
+	return Report{
+		Coefficient: coefficients.Validators,
+		Metrics: []Metric{{
+			Name:        "organizations",
+			Value:       coefficients.Entities,
+			Methodology: fmt.Sprintf("nodes grouped into %d council member organizations by the host named in their description, 33%% threshold", coefficients.EntityCount),
+		}},
+	}, nil
+}
+
+// fetchHederaNodes returns all nodes, following the links to the next page.
+func fetchHederaNodes(ctx context.Context, deps Deps, baseURL string) (Node, error) {
+	var nodes Node
+
+	// Loop over api responses for all pages.
+	for query := "/api/v1/network/nodes"; query != "" && query != "null"; {
+		response, err := fetchHederaPage(ctx, deps, baseURL+query)
+		if err != nil {
+			return nil, err
 		}
+		nodes = append(nodes, response.Nodes...)
+
+		// The next page is null once there is no more data.
+		query = response.Links.Next
+	}
+
+	return nodes, nil
+}
+
+func fetchHederaPage(ctx context.Context, deps Deps, url string) (HederaResponse, error) {
+	resp, err := deps.Get(ctx, url)
+	if err != nil {
+		return HederaResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return HederaResponse{}, fmt.Errorf("mirror node returned status %d for %s", resp.StatusCode, url)
+	}
+
+	// Decode json response to go objects.
+	var response HederaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return HederaResponse{}, err
+	}
 
-		// Assign next page of results to parse (null if empty, otherwise string).
-		page = response.Links.Next
+	return response, nil
+}
 
-		// Break loop where there is no more data.
-		if page == "" || page == "null" { 
-			break 
+// hederaConsensusWeight returns the consensus weight of node in tinybars, along with its rewarded
+// and non-rewarded stake. Mirror nodes without the split only report the stake.
+func hederaConsensusWeight(node HederaNode) (weight, rewarded, notRewarded *big.Int, err error) {
+	parse := func(name string, n json.Number) (*big.Int, error) {
+		if n == "" {
+			return new(big.Int), nil
+		}
+		v, ok := new(big.Int).SetString(n.String(), 10)
+		if !ok || v.Sign() < 0 {
+			return nil, fmt.Errorf("invalid %s %q", name, n)
 		}
+		return v, nil
+	}
+
+	if rewarded, err = parse("stake_rewarded", node.StakeRewarded); err != nil {
+		return nil, nil, nil, err
+	}
+	if notRewarded, err = parse("stake_not_rewarded", node.StakeNotRewarded); err != nil {
+		return nil, nil, nil, err
+	}
+	if node.StakeRewarded == "" && node.StakeNotRewarded == "" {
+		weight, err = parse("stake", node.Stake)
+		return weight, rewarded, notRewarded, err
+	}
+
+	minStake, err := parse("min_stake", node.MinStake)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	maxStake, err := parse("max_stake", node.MaxStake)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 
-		// Assign new query to api call and reset page variable.
-		query = page
-		page = ""
+	weight = new(big.Int).Add(rewarded, notRewarded)
+	if maxStake.Sign() > 0 && weight.Cmp(maxStake) > 0 {
+		weight.Set(maxStake)
+	}
+	if weight.Cmp(minStake) < 0 {
+		weight.SetInt64(0)
 	}
 
-	// Sort the node votes in descending order.
-	sort.Slice(votingPowers, func(i, j int) bool {
-		return (&votingPowers[i]).Cmp(&votingPowers[j]) == 1
-	})	
+	return weight, rewarded, notRewarded, nil
+}
 
-	// Calculate the total voting power.
-	totalVotingPower := utils.CalculateTotalVotingPowerBigNums(votingPowers)
-	fmt.Println("Total voting power for Hedera is:", new(big.Float).SetInt(totalVotingPower))
+// hederaOrganization returns the organization named in a node description such as
+// "Hosted by Google | Mountain View, CA, USA". Descriptions in another format are used as is.
+func hederaOrganization(description string) string {
+	organization, _, _ := strings.Cut(description, "|")
+	organization = strings.TrimSpace(organization)
 
-	// Now we're ready to calculate the nakomoto coefficient.
-	nakamotoCoefficient := utils.CalcNakamotoCoefficientBigNums(totalVotingPower, votingPowers)
-	fmt.Println("The Nakamoto coefficient for Hedera is", nakamotoCoefficient)
+	if len(organization) >= len("hosted by ") && strings.EqualFold(organization[:len("hosted by ")], "hosted by ") {
+		organization = strings.TrimSpace(organization[len("hosted by "):])
+	}
 
-	return nakamotoCoefficient, nil	
-}
\ No newline at end of file
+	return organization
+}
@@ -2,10 +2,10 @@ package chains
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"math/big"
 	"net/http"
 	"sort"
@@ -15,27 +15,29 @@ import (
 )
 
 type HyperliquidValidator struct {
-	Validator string  `json:"validator"` 
+	Validator string  `json:"validator"`
 	Name      string  `json:"name"`
-	Stake     float64 `json:"stake"`  
-	IsActive  bool    `json:"isActive"` 
+	Stake     float64 `json:"stake"`
+	IsActive  bool    `json:"isActive"`
 }
 
 type HyperliquidResponse []HyperliquidValidator
 
-func Hyperliquid() (int, error) {
-	url := "https://api.hyperliquid.xyz/info"
-	
+func Hyperliquid(ctx context.Context, deps Deps) (int, error) {
+	url := deps.Endpoint("hyperliquid", "https://api.hyperliquid.xyz") + "/info"
+
 	payload := []byte(`{"type": "validatorSummaries"}`)
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payload))
+	ctx, cancelFunc := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelFunc()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(payload))
 	if err != nil {
 		return 0, err
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := deps.HTTPClient.Do(req)
 	if err != nil {
 		return 0, err
 	}
@@ -62,7 +64,7 @@ func Hyperliquid() (int, error) {
 		}
 
 		vp := big.NewInt(int64(v.Stake))
-		
+
 		votingPowers = append(votingPowers, vp)
 		totalVotingPower.Add(totalVotingPower, vp)
 		activeCount++
@@ -76,10 +78,10 @@ func Hyperliquid() (int, error) {
 		return votingPowers[i].Cmp(votingPowers[j]) > 0
 	})
 
-	log.Printf("Hyperliquid: Fetched %d active validators. Total Stake: %s", activeCount, totalVotingPower.String())
+	deps.Logger.Printf("Hyperliquid: Fetched %d active validators. Total Stake: %s", activeCount, totalVotingPower.String())
 
 	nakamotoCoefficient := utils.CalcNakamotoCoefficientBigInt(totalVotingPower, votingPowers)
-	log.Printf("The Nakamoto coefficient for Hyperliquid is %d", nakamotoCoefficient)
+	deps.Logger.Printf("The Nakamoto coefficient for Hyperliquid is %d", nakamotoCoefficient)
 
 	return nakamotoCoefficient, nil
-}
\ No newline at end of file
+}
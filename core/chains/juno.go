@@ -1,8 +1,11 @@
 package chains
 
-func Juno() (int, error) {
-	validatorsURL := "https://api.juno.basementnodes.ca/cosmos/staking/v1beta1/validators?page.offset=1&pagination.limit=100&status=BOND_STATUS_BONDED"
-	stakingPoolURL := "https://api.juno.basementnodes.ca/cosmos/staking/v1beta1/pool"
+import "context"
 
-	return FetchCosmosSDKNakaCoeff("juno", validatorsURL, stakingPoolURL)
+func Juno(ctx context.Context, deps Deps) (Report, error) {
+	baseURL := deps.Endpoint("juno", "https://api.juno.basementnodes.ca")
+	validatorsURL := baseURL + "/cosmos/staking/v1beta1/validators?page.offset=1&pagination.limit=100&status=BOND_STATUS_BONDED"
+	stakingPoolURL := baseURL + "/cosmos/staking/v1beta1/pool"
+
+	return FetchCosmosSDKNakaCoeff(ctx, deps, "juno", validatorsURL, stakingPoolURL)
 }
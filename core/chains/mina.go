@@ -3,22 +3,35 @@ package chains
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math/big"
 	"net/http"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
+
+	utils "github.com/xenowits/nakamoto-coefficient-calculator/core/utils"
+)
+
+const (
+	// nanominaDecimals is the number of decimals of a MINA amount.
+	nanominaDecimals = 9
+
+	minaPageSize = 50
+	// minaMaxPages guards against an API that never reports the last page.
+	minaMaxPages = 1000
 )
 
 type MinaResponse struct {
 	Content []struct {
-		Pk             string  `json:"pk"`
-		Name           string  `json:"name"`
-		StakePercent   float64 `json:"stakePercent"`
-		CanonicalBlock int     `json:"canonicalBlock"`
-		SocialTelegram string  `json:"socialTelegram"`
+		Pk             string      `json:"pk"`
+		Name           string      `json:"name"`
+		AmountStaked   json.Number `json:"amountStaked"`
+		StakePercent   float64     `json:"stakePercent"`
+		CanonicalBlock int         `json:"canonicalBlock"`
+		SocialTelegram string      `json:"socialTelegram"`
 	}
 	TotalPages    int `json:"totalPages"`
 	TotalElements int `json:"totalElements"`
@@ -30,90 +43,133 @@ type MinaErrorResponse struct {
 	Error   string `json:"error"`
 }
 
-func reverse(numbers []float64) {
-	for i, j := 0, len(numbers)-1; i < j; i, j = i+1, j-1 {
-		numbers[i], numbers[j] = numbers[j], numbers[i]
-	}
-}
+// Mina calculates the Nakamoto coefficient over the stake delegated to each active block producer,
+// at the 50% threshold. Stakes are the amountStaked reported by the minascan validators API; the
+// staking ledger they are taken from is chosen by minascan, not selected here. Entries sharing a
+// block producer key are aggregated.
+func Mina(ctx context.Context, deps Deps) (int, error) {
+	baseURL := deps.Endpoint("mina", "https://minascan.io")
 
-func Mina() (int, error) {
-	var votingPowers []float64
-	var totalStake float64
-	pageNo, entriesPerPage := 0, 50
-	url := ""
-	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancelFunc()
-	for true {
+	stakePerProducer := make(map[string]*big.Int)
+	for pageNo := 0; pageNo < minaMaxPages; pageNo++ {
 		// Check the most active url in the network logs here: https://mina.staketab.com/validators/stake
 		// Sometimes it changes, like once it changed from mina.staketab.com to t-mina.staketab.com
 		// Once, it was https://mina.staketab.com:8181/api/validator/all/
-		url = fmt.Sprintf("https://minascan.io/mainnet/api/api/validators/?page=%d&size=%d&sortBy=amount_staked&type=active&findStr=&orderBy=DESC", pageNo, entriesPerPage)
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-		if err != nil {
-			log.Println(err)
-			return 0, errors.New("create get request for mina")
-		}
-
-		resp, err := new(http.Client).Do(req)
-		if err != nil {
-			log.Println(err)
-			return 0, errors.New("get request unsuccessful")
-		}
-		defer resp.Body.Close()
+		url := fmt.Sprintf("%s/mainnet/api/api/validators/?page=%d&size=%d&sortBy=amount_staked&type=active&findStr=&orderBy=DESC", baseURL, pageNo, minaPageSize)
 
-		body, err := io.ReadAll(resp.Body)
+		response, err := fetchMinaPage(ctx, deps, url)
 		if err != nil {
-			return 0, err
+			return 0, fmt.Errorf("mina page %d: %w", pageNo, err)
 		}
 
-		var response MinaResponse
-		err = json.Unmarshal(body, &response)
-		if err != nil {
-			return 0, err
+		for _, ele := range response.Content {
+			stake, err := parseDecimalUnits(ele.AmountStaked.String(), nanominaDecimals)
+			if err != nil {
+				return 0, fmt.Errorf("block producer %s: %w", ele.Pk, err)
+			}
+
+			if stakePerProducer[ele.Pk] == nil {
+				stakePerProducer[ele.Pk] = new(big.Int)
+			}
+			stakePerProducer[ele.Pk].Add(stakePerProducer[ele.Pk], stake)
 		}
 
-		// Break if no content or all pages have been fetched
-		if len(response.Content) == 0 || pageNo >= response.TotalPages {
+		// Pages are numbered from 0, so pageNo+1 pages have been fetched. A missing page count is
+		// not trusted to mean there is a single page.
+		if len(response.Content) < minaPageSize || (response.TotalPages > 0 && pageNo+1 >= response.TotalPages) {
 			break
 		}
+	}
 
-		// loop through the validators voting powers
-		for _, ele := range response.Content {
-			votingPowers = append(votingPowers, ele.StakePercent)
-			// Accumulate total stake of all validators
-			totalStake += ele.StakePercent
+	var votingPowers []big.Int
+	for _, stake := range stakePerProducer {
+		if stake.Sign() > 0 {
+			votingPowers = append(votingPowers, *stake)
 		}
-
-		// increment counters
-		pageNo += 1
+	}
+	if len(votingPowers) == 0 {
+		return 0, fmt.Errorf("no block producers with stake found")
 	}
 
 	// Sort voting powers in descending order
 	sort.Slice(votingPowers, func(i, j int) bool {
-		return votingPowers[i] > votingPowers[j]
+		return votingPowers[i].Cmp(&votingPowers[j]) > 0
 	})
 
+	totalStake := utils.CalculateTotalVotingPowerBigNums(votingPowers)
+	deps.Logger.Printf("Mina: %d block producers with %s nanomina delegated", len(votingPowers), totalStake)
+
 	// now we're ready to calculate the Nakamoto coefficient
-	nakamotoCoefficient := calcNakamotoCoefficientForMina(votingPowers, totalStake)
-	fmt.Println("The Nakamoto coefficient for Mina is", nakamotoCoefficient)
+	nakamotoCoefficient := utils.CalcNakamotoCoefficientBigNums51(totalStake, votingPowers)
+	deps.Logger.Println("The Nakamoto coefficient for Mina is", nakamotoCoefficient)
 
 	return nakamotoCoefficient, nil
 }
 
-func calcNakamotoCoefficientForMina(votingPowers []float64, totalStake float64) int {
-	// Calculate 50% threshold dynamically based on total stake
-	var cumulativePercent float64 = 0.00
-	thresholdPercent := totalStake * 0.50
-	nakamotoCoefficient := 0
-
-	for _, vpp := range votingPowers {
-		// since this is the actual voting percentage, no need to multiply with 100
-		cumulativePercent += vpp
-		nakamotoCoefficient += 1
-		// Check if cumulative voting power exceeds 50% of total stake
-		if cumulativePercent >= thresholdPercent {
-			break
+// fetchMinaPage fetches a single page of validators, each with its own timeout.
+func fetchMinaPage(ctx context.Context, deps Deps, url string) (MinaResponse, error) {
+	ctx, cancelFunc := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelFunc()
+
+	resp, err := deps.Get(ctx, url)
+	if err != nil {
+		return MinaResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return MinaResponse{}, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp MinaErrorResponse
+		if json.Unmarshal(body, &errResp) == nil && errResp.Error != "" {
+			return MinaResponse{}, fmt.Errorf("minascan returned status %d: %s", resp.StatusCode, errResp.Error)
+		}
+		return MinaResponse{}, fmt.Errorf("minascan returned status %d", resp.StatusCode)
+	}
+
+	var response MinaResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return MinaResponse{}, err
+	}
+
+	return response, nil
+}
+
+// parseDecimalUnits parses a non-negative decimal amount such as "1234.5" or "1.2345E7" into an
+// integer number of units with the given number of decimals, without going through floating
+// point. Digits below the smallest unit are truncated.
+func parseDecimalUnits(amount string, decimals int) (*big.Int, error) {
+	mantissa, exponent := amount, 0
+	if i := strings.IndexAny(amount, "eE"); i >= 0 {
+		var err error
+		if exponent, err = strconv.Atoi(amount[i+1:]); err != nil {
+			return nil, fmt.Errorf("invalid amount %q", amount)
 		}
+		mantissa = amount[:i]
+	}
+
+	whole, frac, _ := strings.Cut(mantissa, ".")
+	digits := strings.TrimLeft(whole+frac, "0")
+	if strings.Trim(whole+frac, "0123456789") != "" || whole+frac == "" {
+		return nil, fmt.Errorf("invalid amount %q", amount)
 	}
-	return nakamotoCoefficient
+
+	scale := decimals + exponent - len(frac)
+	if scale >= 0 {
+		digits += strings.Repeat("0", scale)
+	} else if len(digits) > -scale {
+		digits = digits[:len(digits)+scale]
+	} else {
+		digits = ""
+	}
+	if digits == "" {
+		return new(big.Int), nil
+	}
+
+	n, _ := new(big.Int).SetString(digits, 10)
+
+	return n, nil
 }
@@ -0,0 +1,42 @@
+package chains
+
+import "testing"
+
+func TestParseDecimalUnits(t *testing.T) {
+	tests := []struct {
+		amount string
+		want   string
+	}{
+		{"0", "0"},
+		{"1", "1000000000"},
+		{"1234.5", "1234500000000"},
+		{"0.000000001", "1"},
+		{".5", "500000000"},
+		{"1.", "1000000000"},
+		{"1.2345E7", "12345000000000000"},
+		{"1.5e-9", "1"},
+		// Digits below a nanomina are truncated.
+		{"0.0000000019", "1"},
+		{"0.0000000009", "0"},
+		{"12.3456789019", "12345678901"},
+	}
+
+	for _, tt := range tests {
+		got, err := parseDecimalUnits(tt.amount, nanominaDecimals)
+		if err != nil {
+			t.Errorf("parseDecimalUnits(%q): %v", tt.amount, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("parseDecimalUnits(%q) = %s, want %s", tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestParseDecimalUnitsErrors(t *testing.T) {
+	for _, amount := range []string{"", ".", "-1", "-0.5", "+1", "1e", "1e1.5", "1.2.3", "abc", "1,000"} {
+		if got, err := parseDecimalUnits(amount, nanominaDecimals); err == nil {
+			t.Errorf("parseDecimalUnits(%q) = %s, want error", amount, got)
+		}
+	}
+}
@@ -1,17 +1,16 @@
 package chains
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
-	"log"
 	"math/big"
 	"net/http"
 	"sort"
 	"strings"
-	"time"
+	"sync"
 
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/abi"
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/jsonrpc"
 	utils "github.com/xenowits/nakamoto-coefficient-calculator/core/utils"
 )
 
@@ -22,42 +21,40 @@ const (
 	// Selectors for Monad Staking Precompile
 	SelectorGetValSet  = "fb29b729"
 	SelectorGetValInfo = "2b6d639a"
-)
 
-type RpcRequest struct {
-	JsonRpc string        `json:"jsonrpc"`
-	Method  string        `json:"method"`
-	Params  []interface{} `json:"params"`
-	Id      int           `json:"id"`
-}
+	// monadUserAgent is sent as the User-Agent of every request to the Monad RPC.
+	monadUserAgent = "Nakaflow/1.0"
 
-type RpcResponse struct {
-	Result string `json:"result"`
-	Error  struct {
-		Code    int    `json:"code"`
-		Message string `json:"message"`
-	} `json:"error"`
-}
+	// Word of the consensus stake in the getValidator tuple (authAddress, flags, stake,
+	// accRewardPerToken, commission, unclaimedRewards, consensusStake, ...).
+	monadConsensusStakeWord = 6
+
+	defaultMonadBatchSize   = 50
+	defaultMonadConcurrency = 4
+)
 
-func Monad() (int, error) {
+// Monad calculates the Nakamoto coefficient over the consensus stake of the validator set reported
+// by the staking precompile. Validator stakes are fetched in batches of "monad_batch_size" eth_calls
+// per request, with up to "monad_concurrency" requests in flight.
+func Monad(ctx context.Context, deps Deps) (int, error) {
 	// 1. Get all validator IDs via pagination
-	valIDs, err := fetchAllValidatorIDs()
+	rpcURL := deps.Endpoint("monad", MonadRPC)
+
+	valIDs, err := fetchAllValidatorIDs(ctx, deps, rpcURL)
 	if err != nil {
 		return 0, err
 	}
-	log.Printf("Found %d active validators on Monad", len(valIDs))
-
-	var votingPowers []big.Int
+	deps.Logger.Printf("Found %d active validators on Monad", len(valIDs))
 
 	// 2. Fetch stake for each validator
-	for _, id := range valIDs {
-		time.Sleep(50 * time.Millisecond)
-		stake, err := fetchValidatorStake(id)
-		if err != nil {
-			log.Printf("Failed to fetch stake for ValID %s: %v", id.String(), err)
-			continue
-		}
-		if stake.Cmp(big.NewInt(0)) > 0 {
+	stakes, err := fetchValidatorStakes(ctx, deps, rpcURL, valIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	var votingPowers []big.Int
+	for _, stake := range stakes {
+		if stake.Sign() > 0 {
 			votingPowers = append(votingPowers, *stake)
 		}
 	}
@@ -73,101 +70,161 @@ func Monad() (int, error) {
 
 	totalStake := utils.CalculateTotalVotingPowerBigNums(votingPowers)
 
-	fmt.Println("Total Monad Stake:", new(big.Float).SetInt(totalStake))
+	deps.Logger.Println("Total Monad Stake:", new(big.Float).SetInt(totalStake))
 
 	nc := utils.CalcNakamotoCoefficientBigNums(totalStake, votingPowers)
-	fmt.Println("Monad Nakamoto Coefficient:", nc)
+	deps.Logger.Println("Monad Nakamoto Coefficient:", nc)
 
 	return nc, nil
 }
 
 // fetchAllValidatorIDs paginates through the system contract to retrieve all validator IDs.
-func fetchAllValidatorIDs() ([]*big.Int, error) {
+func fetchAllValidatorIDs(ctx context.Context, deps Deps, rpcURL string) ([]*big.Int, error) {
 	var allIDs []*big.Int
 	currentIndex := 0
 
 	for {
-		// Payload: selector + current_index (uint256 encoded)
-		arg := fmt.Sprintf("%064x", currentIndex)
-		data := "0x" + SelectorGetValSet + arg
-
-		res, err := ethCall(data)
+		res, err := ethCall(ctx, deps, rpcURL, abi.EncodeCall(SelectorGetValSet, big.NewInt(int64(currentIndex))))
 		if err != nil {
 			return nil, err
 		}
 
-		res = strings.TrimPrefix(res, "0x")
+		// Response ABI: (bool isDone, uint32 nextIndex, uint64[] valIds)
+		data, err := abi.Decode(res)
+		if err != nil {
+			return nil, fmt.Errorf("validator set at index %d: %w", currentIndex, err)
+		}
+
+		isDone, err := data.Bool(0)
+		if err != nil {
+			return nil, fmt.Errorf("validator set at index %d: %w", currentIndex, err)
+		}
 
-		// Response ABI: [bool is_done, uint256 next_index, uint256 offset, uint256 length, ...items]
-		if len(res) < 256 {
-			return nil, fmt.Errorf("response too short during pagination")
+		nextIndex, err := data.Int(1)
+		if err != nil {
+			return nil, fmt.Errorf("validator set at index %d: %w", currentIndex, err)
 		}
 
-		// Parse is_done (Word 0)
-		isDoneHex := res[0:64]
-		isDoneVal, _ := new(big.Int).SetString(isDoneHex, 16)
-		isDone := isDoneVal.Cmp(big.NewInt(1)) == 0
-
-		// Parse next_index (Word 1)
-		nextIndexHex := res[64:128]
-		nextIndexBig, _ := new(big.Int).SetString(nextIndexHex, 16)
-		currentIndex = int(nextIndexBig.Int64())
-
-		// Parse array length (Word 3)
-		lenHex := res[192:256]
-		countBig, _ := new(big.Int).SetString(lenHex, 16)
-		count := int(countBig.Int64())
-
-		// Extract items starting at offset 256
-		dataStart := 256
-		for i := 0; i < count; i++ {
-			p := dataStart + (i * 64)
-			if p+64 > len(res) {
-				break
-			}
-			segment := res[p : p+64]
-			val := new(big.Int)
-			val.SetString(segment, 16)
-			allIDs = append(allIDs, val)
+		ids, err := data.UintArray(2)
+		if err != nil {
+			return nil, fmt.Errorf("validator set at index %d: %w", currentIndex, err)
 		}
+		allIDs = append(allIDs, ids...)
 
 		if isDone {
 			break
 		}
+		if nextIndex <= currentIndex {
+			return nil, fmt.Errorf("validator set pagination did not advance past index %d", currentIndex)
+		}
+		currentIndex = nextIndex
 	}
 
 	return allIDs, nil
 }
 
-func fetchValidatorStake(valID *big.Int) (*big.Int, error) {
-	// Payload: selector + val_id (uint256 encoded)
-	arg := fmt.Sprintf("%064x", valID)
-	data := "0x" + SelectorGetValInfo + arg
+// fetchValidatorStakes returns the consensus stake of each validator in valIDs, in order.
+func fetchValidatorStakes(ctx context.Context, deps Deps, rpcURL string, valIDs []*big.Int) ([]*big.Int, error) {
+	batchSize := deps.SettingInt("monad_batch_size", defaultMonadBatchSize)
+	concurrency := deps.SettingInt("monad_concurrency", defaultMonadConcurrency)
+	if batchSize < 1 || concurrency < 1 {
+		return nil, fmt.Errorf("monad batch size and concurrency must be positive")
+	}
 
-	res, err := ethCall(data)
-	if err != nil {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		stakes   = make([]*big.Int, len(valIDs))
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		sem      = make(chan struct{}, concurrency)
+	)
+
+	for start := 0; start < len(valIDs); start += batchSize {
+		end := start + batchSize
+		if end > len(valIDs) {
+			end = len(valIDs)
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := fetchValidatorStakeBatch(ctx, deps, rpcURL, valIDs[start:end], stakes[start:end]); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(start, end)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
 
-	res = strings.TrimPrefix(res, "0x")
+	return stakes, nil
+}
+
+// fetchValidatorStakeBatch fetches the consensus stake of valIDs in a single batch request and
+// stores it in the corresponding element of stakes.
+func fetchValidatorStakeBatch(ctx context.Context, deps Deps, rpcURL string, valIDs []*big.Int, stakes []*big.Int) error {
+	requests := make([]jsonrpc.Request, 0, len(valIDs))
+	for _, id := range valIDs {
+		requests = append(requests, ethCallRequest(abi.EncodeCall(SelectorGetValInfo, id)))
+	}
+
+	responses, err := monadRPC(deps, rpcURL).Batch(ctx, requests)
+	if err != nil {
+		return err
+	}
+
+	for i, resp := range responses {
+		var res string
+		if err := resp.Decode(&res); err != nil {
+			return fmt.Errorf("validator %s: %w", valIDs[i], err)
+		}
+
+		data, err := abi.Decode(res)
+		if err != nil {
+			return fmt.Errorf("validator %s: %w", valIDs[i], err)
+		}
 
-	// ValidatorInfo struct layout: Stake is at index 6 (offset 192 bytes / 384 hex chars)
-	start := 384
-	if len(res) < start+64 {
-		return nil, fmt.Errorf("response too short for validator info")
+		stakes[i], err = data.Uint(monadConsensusStakeWord)
+		if err != nil {
+			return fmt.Errorf("validator %s: %w", valIDs[i], err)
+		}
 	}
 
-	stakeHex := res[start : start+64]
-	stake := new(big.Int)
-	stake.SetString(stakeHex, 16)
+	return nil
+}
+
+// monadRPC returns a JSON-RPC client for the Monad endpoint at rpcURL.
+func monadRPC(deps Deps, rpcURL string) *jsonrpc.Client {
+	client := deps.RPC(rpcURL)
+	client.Header = http.Header{"User-Agent": {monadUserAgent}}
 
-	return stake, nil
+	return client
 }
 
-func ethCall(data string) (string, error) {
-	payload := RpcRequest{
-		JsonRpc: "2.0",
-		Method:  "eth_call",
+func ethCallRequest(data string) jsonrpc.Request {
+	return jsonrpc.Request{
+		Method: "eth_call",
 		Params: []interface{}{
 			map[string]string{
 				"to":   ContractAddr,
@@ -175,35 +232,16 @@ func ethCall(data string) (string, error) {
 			},
 			"latest",
 		},
-		Id: 1,
 	}
+}
 
-	bodyBytes, _ := json.Marshal(payload)
-	req, _ := http.NewRequest("POST", MonadRPC, bytes.NewBuffer(bodyBytes))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", "Nakaflow/1.0")
+func ethCall(ctx context.Context, deps Deps, rpcURL string, data string) (string, error) {
+	req := ethCallRequest(data)
 
-	client := &http.Client{Timeout: 15 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
+	var res string
+	if err := monadRPC(deps, rpcURL).Call(ctx, req.Method, req.Params, &res); err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
-
-	respBody, _ := io.ReadAll(resp.Body)
-
-	if bytes.HasPrefix(bytes.TrimSpace(respBody), []byte("<")) {
-		return "", fmt.Errorf("RPC returned HTML content")
-	}
-
-	var rpcResp RpcResponse
-	if err := json.Unmarshal(respBody, &rpcResp); err != nil {
-		return "", fmt.Errorf("json parse error: %v", err)
-	}
-
-	if rpcResp.Error.Code != 0 {
-		return "", fmt.Errorf("rpc error: %s", rpcResp.Error.Message)
-	}
 
-	return rpcResp.Result, nil
+	return strings.TrimSpace(res), nil
 }
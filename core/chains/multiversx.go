@@ -1,70 +1,112 @@
 package chains
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"math/big"
 	"net/http"
-	"sort"
 
-	"github.com/xenowits/nakamoto-coefficient-calculator/core/utils"
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/entities"
 )
 
-const totalValidatorsUrl = "https://api.multiversx.com/stake"
-const identitiesUrl = "https://api.multiversx.com/identities"
+const (
+	multiversXBaseURL = "https://api.multiversx.com"
+
+	multiversXNodesPageSize = 500
+	// multiversXMaxNodes is the largest result window of the MultiversX API.
+	multiversXMaxNodes = 10000
+)
 
 type MultiversXTotalValidatorsResponse struct {
 	TotalValidators int64 `json:"totalValidators"`
 }
 
-type MultiversXIdentitiesResponse []struct {
-	Locked        string `json:"locked"`
-	NumValidators int64  `json:"validators"`
+type MultiversXNode struct {
+	Bls      string `json:"bls"`
+	Identity string `json:"identity"`
+	Provider string `json:"provider"`
+	Locked   string `json:"locked"`
+	Status   string `json:"status"`
 }
 
-func MultiversX() (int, error) {
-	numValidatorsPerIdentity := make([]int64, 0)
+// MultiversX calculates the Nakamoto coefficient over validator seats: there is a fixed number of
+// validator seats, and the coefficient is the number of node operators controlling more than 33% of
+// them. The coefficient over the EGLD locked by each operator is reported as the "stake" metric.
+//
+// Nodes are attributed to their identity, then to their staking provider, and are otherwise
+// counted as operators of their own. The "multiversx_entities" mapping takes precedence.
+func MultiversX(ctx context.Context, deps Deps) (Report, error) {
+	baseURL := deps.Endpoint("multiversx", multiversXBaseURL)
 
-	totalNumberOfValidators, err := getTotalValidatorsNumber()
+	mapping, err := entities.Load(ctx, deps, deps.Setting("multiversx_entities", ""))
 	if err != nil {
-		return 0, err
+		return Report{}, fmt.Errorf("failed to load entities for multiversx: %w", err)
 	}
 
-	identities, err := getIdentities()
+	totalNumberOfValidators, err := getTotalValidatorsNumber(ctx, deps, baseURL+"/stake")
 	if err != nil {
-		return 0, err
+		return Report{}, err
+	}
+
+	nodes, err := getValidatorNodes(ctx, deps, baseURL)
+	if err != nil {
+		return Report{}, err
 	}
 
-	for _, identity := range identities {
-		if identity.Locked == "0" {
+	var seats, stakes []entities.Validator
+	for _, node := range nodes {
+		if node.Status != "eligible" && node.Status != "waiting" {
 			continue
 		}
-		numValidatorsPerIdentity = append(numValidatorsPerIdentity, identity.NumValidators)
-	}
 
-	sort.Slice(numValidatorsPerIdentity, func(i, j int) bool {
-		return numValidatorsPerIdentity[i] > numValidatorsPerIdentity[j]
-	})
+		locked, ok := new(big.Int).SetString(node.Locked, 10)
+		if !ok {
+			if node.Locked != "" {
+				return Report{}, fmt.Errorf("node %s: invalid locked amount %q", node.Bls, node.Locked)
+			}
+			locked = new(big.Int)
+		}
+
+		operator := node.Identity
+		if operator == "" && node.Provider != "" {
+			operator = "provider:" + node.Provider
+		}
 
-	fmt.Println("Total voting power:", totalNumberOfValidators)
+		seats = append(seats, entities.Validator{Address: node.Bls, Identity: operator, Stake: big.NewInt(1)})
+		stakes = append(stakes, entities.Validator{Address: node.Bls, Identity: operator, Stake: locked})
+	}
+	if len(seats) == 0 {
+		return Report{}, fmt.Errorf("no eligible or waiting validator nodes found")
+	}
+	if totalNumberOfValidators < int64(len(seats)) {
+		totalNumberOfValidators = int64(len(seats))
+	}
 
-	// there is a fixed number of validator seats in MultiversX - currently 3200
-	// the Nakamoto coefficient can be computed by counting the identities (node operators)
-	// that control more than 33% of the total number of validators
-	nakamotoCoefficient := utils.CalcNakamotoCoefficient(totalNumberOfValidators, numValidatorsPerIdentity)
-	fmt.Println("The Nakamoto coefficient for MultiversX is", nakamotoCoefficient)
+	deps.Logger.Printf("MultiversX: %d validator seats, %d nodes found", totalNumberOfValidators, len(seats))
 
-	return nakamotoCoefficient, nil
+	seatCoefficients := entities.Calculate(seats, mapping, big.NewInt(totalNumberOfValidators))
+	stakeCoefficients := entities.Calculate(stakes, mapping, nil)
+	deps.Logger.Println("The Nakamoto coefficient for MultiversX is", seatCoefficients.Entities)
+
+	return Report{
+		Coefficient: seatCoefficients.Entities,
+		Metrics: []Metric{{
+			Name:        "stake",
+			Value:       stakeCoefficients.Entities,
+			Methodology: fmt.Sprintf("EGLD locked by each of %d operators, grouping nodes by identity, then staking provider, 33%% threshold", stakeCoefficients.EntityCount),
+		}},
+	}, nil
 }
 
-func getTotalValidatorsNumber() (int64, error) {
-	resp, err := http.Get(totalValidatorsUrl)
+func getTotalValidatorsNumber(ctx context.Context, deps Deps, url string) (int64, error) {
+	resp, err := deps.Get(ctx, url)
 	if err != nil {
 		return 0, err
 	}
 
-	defer closeBody(resp)
+	defer closeBody(deps, resp)
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -80,33 +122,44 @@ func getTotalValidatorsNumber() (int64, error) {
 	return response.TotalValidators, nil
 }
 
-func getIdentities() (MultiversXIdentitiesResponse, error) {
-	resp, err := http.Get(identitiesUrl)
-	if err != nil {
-		return nil, err
-	}
+// getValidatorNodes pages through the validator nodes of the network.
+func getValidatorNodes(ctx context.Context, deps Deps, baseURL string) ([]MultiversXNode, error) {
+	var nodes []MultiversXNode
+	for from := 0; from < multiversXMaxNodes; from += multiversXNodesPageSize {
+		url := fmt.Sprintf("%s/nodes?type=validator&from=%d&size=%d&fields=bls,identity,provider,locked,status", baseURL, from, multiversXNodesPageSize)
+		resp, err := deps.Get(ctx, url)
+		if err != nil {
+			return nil, err
+		}
 
-	defer closeBody(resp)
+		body, err := io.ReadAll(resp.Body)
+		closeBody(deps, resp)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("multiversx nodes returned status %d", resp.StatusCode)
+		}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
+		var page []MultiversXNode
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, page...)
 
-	var response MultiversXIdentitiesResponse
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		return nil, err
+		if len(page) < multiversXNodesPageSize {
+			break
+		}
 	}
 
-	return response, nil
+	return nodes, nil
 }
 
-func closeBody(resp *http.Response) {
+func closeBody(deps Deps, resp *http.Response) {
 	if resp == nil || resp.Body == nil {
 		return
 	}
 	if closeErr := resp.Body.Close(); closeErr != nil {
-		log.Printf("failed to close response body: %s", closeErr)
+		deps.Logger.Printf("failed to close response body: %s", closeErr)
 	}
 }
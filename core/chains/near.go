@@ -1,94 +1,308 @@
 package chains
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/xenowits/nakamoto-coefficient-calculator/core/utils"
-	"io/ioutil"
-	"log"
 	"math/big"
-	"net/http"
 	"sort"
+	"sync"
+
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/entities"
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/jsonrpc"
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/utils"
+)
+
+const (
+	defaultNearSeats       = 300
+	defaultNearConcurrency = 8
 )
 
-type NearResponse struct {
-	Jsonrpc string `json:"jsonrpc"`
-	Id      int    `json:"id"`
-	Result  struct {
-		Validators []struct {
-			AccountId string `json:"account_id"`
-			Stake     string `json:"stake"`
-		} `json:"current_validators"`
-	} `json:"result"`
+type NearValidator struct {
+	AccountId string `json:"account_id"`
+	Stake     string `json:"stake"`
 }
 
-func Near() (int, error) {
-	votingPowers := make([]big.Int, 0, 1024)
+type NearValidatorsResult struct {
+	Validators       []NearValidator `json:"current_validators"`
+	NextValidators   []NearValidator `json:"next_validators"`
+	CurrentProposals []NearValidator `json:"current_proposals"`
+}
+
+type NearProtocolConfig struct {
+	NumBlockProducerSeats int `json:"num_block_producer_seats"`
+	// MinimumStakeRatio is the smallest fraction of the total stake a validator may hold.
+	MinimumStakeRatio [2]int64 `json:"minimum_stake_ratio"`
+}
 
-	url := fmt.Sprintf("https://rpc.mainnet.near.org")
-	jsonReqData := []byte(`{"jsonrpc": "2.0","method": "validators","params":[null],"id":1}`)
+// Near calculates the Nakamoto coefficient over the stake of the current validators.
+//
+// The coefficients the network is about to have are reported as metrics: "next_epoch" over the
+// validators of the next epoch and "proposals" over the validators projected for the epoch after,
+// by applying the current proposals to the next validators and pricing seats with the protocol
+// config. Unless the "near_owners" setting is "false", staking pools are grouped by the owner
+// account of their contract and the coefficient over owners is reported as "owners". The
+// "proposals" and "owners" metrics are left out, and the failure logged, when the protocol config
+// or the pool owners cannot be read.
+func Near(ctx context.Context, deps Deps) (Report, error) {
+	url := deps.Endpoint("near", "https://rpc.mainnet.near.org")
+
+	var response NearValidatorsResult
+	if err := deps.RPC(url).Call(ctx, "validators", []interface{}{nil}, &response); err != nil {
+		return Report{}, err
+	}
 
-	// Create a new POST request using http
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonReqData))
+	current, err := nearStakes(response.Validators)
 	if err != nil {
-		return 0, err
+		return Report{}, fmt.Errorf("current validators: %w", err)
+	}
+	next, err := nearStakes(response.NextValidators)
+	if err != nil {
+		return Report{}, fmt.Errorf("next validators: %w", err)
+	}
+	proposals, err := nearStakes(response.CurrentProposals)
+	if err != nil {
+		return Report{}, fmt.Errorf("current proposals: %w", err)
+	}
+	if len(current) == 0 {
+		return Report{}, fmt.Errorf("no current validators found")
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	// Send req using http Client
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	nakamotoCoefficient := nearCoefficient(current)
+	deps.Logger.Println("The Nakamoto coefficient for near protocol is", nakamotoCoefficient)
 
-	if resp != nil {
-		// Need to close body when redirection occurs
-		// In redirection, response is not empty
-		defer func() {
-			if err := resp.Body.Close(); err != nil {
-				log.Printf("failed to close response body")
-			}
-		}()
+	var metrics []Metric
+	if len(next) > 0 {
+		metrics = append(metrics, Metric{
+			Name:        "next_epoch",
+			Value:       nearCoefficient(next),
+			Methodology: fmt.Sprintf("stake of the %d validators of the next epoch, 33%% threshold", len(next)),
+		})
 	}
 
-	if err != nil {
-		return 0, err
+	if config, err := nearProtocolConfig(ctx, deps, url); err != nil {
+		deps.Logger.Printf("Skipping Near proposals: protocol config: %v", err)
+	} else if projected := nearProjectProposals(next, proposals, config); len(projected) > 0 {
+		metrics = append(metrics, Metric{
+			Name:  "proposals",
+			Value: nearCoefficient(projected),
+			Methodology: fmt.Sprintf("stake of the %d validators projected from %d proposals, priced into at most %d seats with a minimum stake ratio of %d/%d, 33%% threshold",
+				len(projected), len(proposals), config.NumBlockProducerSeats, config.MinimumStakeRatio[0], config.MinimumStakeRatio[1]),
+		})
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return 0, err
-	}
+	if deps.Setting("near_owners", "true") != "false" {
+		mapping, err := entities.Load(ctx, deps, deps.Setting("near_entities", ""))
+		if err != nil {
+			return Report{}, fmt.Errorf("failed to load entities for near: %w", err)
+		}
 
-	var response NearResponse
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		return 0, err
+		if validators, err := nearPoolOwners(ctx, deps, url, current); err != nil {
+			deps.Logger.Printf("Skipping Near owners: %v", err)
+		} else {
+			coefficients := entities.Calculate(validators, mapping, nil)
+			metrics = append(metrics, Metric{
+				Name:        "owners",
+				Value:       coefficients.Entities,
+				Methodology: fmt.Sprintf("current validators grouped into %d owners by the owner account of their staking pool, 33%% threshold", coefficients.EntityCount),
+			})
+		}
 	}
 
-	// loop through the validators voting powers
-	for _, ele := range response.Result.Validators {
+	return Report{Coefficient: nakamotoCoefficient, Metrics: metrics}, nil
+}
+
+// nearStakes parses the stake of each validator in yoctoNEAR, keyed by account.
+func nearStakes(validators []NearValidator) (map[string]*big.Int, error) {
+	stakes := make(map[string]*big.Int, len(validators))
+	for _, ele := range validators {
 		n, ok := new(big.Int).SetString(ele.Stake, 10)
 		if !ok {
-			return 0, fmt.Errorf("failed to parse string %s", ele.Stake)
+			return nil, fmt.Errorf("failed to parse string %s", ele.Stake)
 		}
-		votingPowers = append(votingPowers, *n)
+		stakes[ele.AccountId] = n
+	}
+
+	return stakes, nil
+}
+
+func nearCoefficient(stakes map[string]*big.Int) int {
+	votingPowers := make([]big.Int, 0, len(stakes))
+	for _, stake := range stakes {
+		votingPowers = append(votingPowers, *stake)
 	}
 
 	// need to sort the powers in descending order since they are in random order
 	sort.Slice(votingPowers, func(i, j int) bool {
-		res := (&votingPowers[i]).Cmp(&votingPowers[j])
-		if res == 1 {
-			return true
-		}
-		return false
+		return (&votingPowers[i]).Cmp(&votingPowers[j]) > 0
 	})
 
 	totalVotingPower := utils.CalculateTotalVotingPowerBigNums(votingPowers)
-	fmt.Println("Total voting power:", totalVotingPower)
 
-	// now we're ready to calculate the nakamoto coefficient
-	nakamotoCoefficient := utils.CalcNakamotoCoefficientBigNums(totalVotingPower, votingPowers)
-	fmt.Println("The Nakamoto coefficient for near protocol is", nakamotoCoefficient)
+	return utils.CalcNakamotoCoefficientBigNums(totalVotingPower, votingPowers)
+}
+
+// nearProtocolConfig returns the seat configuration of the protocol.
+func nearProtocolConfig(ctx context.Context, deps Deps, url string) (NearProtocolConfig, error) {
+	var config NearProtocolConfig
+	params := map[string]string{"finality": "final"}
+	if err := deps.RPC(url).Call(ctx, "EXPERIMENTAL_protocol_config", params, &config); err != nil {
+		return NearProtocolConfig{}, err
+	}
+
+	if config.NumBlockProducerSeats <= 0 {
+		config.NumBlockProducerSeats = defaultNearSeats
+	}
+	if config.MinimumStakeRatio[1] <= 0 {
+		return NearProtocolConfig{}, fmt.Errorf("invalid minimum stake ratio %v", config.MinimumStakeRatio)
+	}
+
+	return config, nil
+}
+
+// nearProjectProposals projects the validators of the epoch after next: the proposals replace the
+// stake of the next validators, a zero stake withdrawing the validator, and only the largest
+// stakes fitting in the seats and holding at least the minimum stake ratio are kept.
+func nearProjectProposals(next, proposals map[string]*big.Int, config NearProtocolConfig) map[string]*big.Int {
+	candidates := make(map[string]*big.Int, len(next)+len(proposals))
+	for account, stake := range next {
+		candidates[account] = stake
+	}
+	for account, stake := range proposals {
+		if stake.Sign() == 0 {
+			delete(candidates, account)
+			continue
+		}
+		candidates[account] = stake
+	}
+
+	accounts := make([]string, 0, len(candidates))
+	for account := range candidates {
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return candidates[accounts[i]].Cmp(candidates[accounts[j]]) > 0
+	})
+	if len(accounts) > config.NumBlockProducerSeats {
+		accounts = accounts[:config.NumBlockProducerSeats]
+	}
+
+	total := new(big.Int)
+	for _, account := range accounts {
+		total.Add(total, candidates[account])
+	}
+	seatPrice := new(big.Int).Mul(total, big.NewInt(config.MinimumStakeRatio[0]))
+	seatPrice.Div(seatPrice, big.NewInt(config.MinimumStakeRatio[1]))
+
+	projected := make(map[string]*big.Int, len(accounts))
+	for _, account := range accounts {
+		if candidates[account].Cmp(seatPrice) >= 0 {
+			projected[account] = candidates[account]
+		}
+	}
+
+	return projected
+}
+
+// nearPoolOwners returns the current validators with the owner account of their staking pool as
+// identity, calling get_owner_id on "near_concurrency" pools at a time. Pools that are not standard
+// staking pool contracts are their own owner.
+func nearPoolOwners(ctx context.Context, deps Deps, url string, stakes map[string]*big.Int) ([]entities.Validator, error) {
+	concurrency := deps.SettingInt("near_concurrency", defaultNearConcurrency)
+	if concurrency < 1 {
+		return nil, fmt.Errorf("near concurrency must be positive")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		validators = make([]entities.Validator, 0, len(stakes))
+		mu         sync.Mutex
+		wg         sync.WaitGroup
+		once       sync.Once
+		firstErr   error
+		sem        = make(chan struct{}, concurrency)
+	)
+
+	for account, stake := range stakes {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(account string, stake *big.Int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			owner, err := nearPoolOwner(ctx, deps, url, account)
+			if err != nil {
+				once.Do(func() {
+					firstErr = fmt.Errorf("pool %s: %w", account, err)
+					cancel()
+				})
+				return
+			}
+
+			mu.Lock()
+			validators = append(validators, entities.Validator{Address: account, Identity: owner, Stake: stake})
+			mu.Unlock()
+		}(account, stake)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return validators, nil
+}
+
+// nearPoolOwner returns the owner account of the staking pool contract at account, or an empty
+// string if the contract does not implement get_owner_id.
+func nearPoolOwner(ctx context.Context, deps Deps, url, account string) (string, error) {
+	params := map[string]string{
+		"request_type": "call_function",
+		"finality":     "final",
+		"account_id":   account,
+		"method_name":  "get_owner_id",
+		"args_base64":  "e30=", // {}
+	}
+
+	// The return value is a JSON array of bytes.
+	var result struct {
+		Result []int  `json:"result"`
+		Error  string `json:"error"`
+	}
+	var rpcErr *jsonrpc.Error
+	err := deps.RPC(url).Call(ctx, "query", params, &result)
+	if errors.As(err, &rpcErr) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	if result.Error != "" {
+		return "", nil
+	}
+
+	raw := make([]byte, len(result.Result))
+	for i, b := range result.Result {
+		raw[i] = byte(b)
+	}
+
+	var owner string
+	if err := json.Unmarshal(raw, &owner); err != nil {
+		deps.Logger.Printf("Near pool %s returned an invalid owner %q, treating it as its own owner: %v", account, raw, err)
+		return "", nil
+	}
 
-	return nakamotoCoefficient, nil
+	return owner, nil
 }
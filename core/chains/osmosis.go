@@ -1,8 +1,11 @@
 package chains
 
-func Osmosis() (int, error) {
-	validatorURL := "https://rest.osmosis.goldenratiostaking.net/cosmos/staking/v1beta1/validators?page.offset=1&pagination.limit=500&status=BOND_STATUS_BONDED"
-	stakingPoolURL := "https://rest.osmosis.goldenratiostaking.net/cosmos/staking/v1beta1/pool"
+import "context"
 
-	return FetchCosmosSDKNakaCoeff("osmosis", validatorURL, stakingPoolURL)
+func Osmosis(ctx context.Context, deps Deps) (Report, error) {
+	baseURL := deps.Endpoint("osmosis", "https://rest.osmosis.goldenratiostaking.net")
+	validatorURL := baseURL + "/cosmos/staking/v1beta1/validators?page.offset=1&pagination.limit=500&status=BOND_STATUS_BONDED"
+	stakingPoolURL := baseURL + "/cosmos/staking/v1beta1/pool"
+
+	return FetchCosmosSDKNakaCoeff(ctx, deps, "osmosis", validatorURL, stakingPoolURL)
 }
@@ -4,9 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"sort"
 	"time"
@@ -20,21 +18,21 @@ type PolygonResponse struct {
 	} `json:"list"`
 }
 
-func Polygon() (int, error) {
+func Polygon(ctx context.Context, deps Deps) (int, error) {
 	var votingPowers []int64
-	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancelFunc := context.WithTimeout(ctx, 10*time.Second)
 	defer cancelFunc()
 
-	url := fmt.Sprintf("https://validator.info/api/polygon/validators?timeframe=week&nameContains=&activeValidators=true")
+	url := deps.Endpoint("polygon", "https://validator.info") + "/api/polygon/validators?timeframe=week&nameContains=&activeValidators=true"
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		log.Println(err)
+		deps.Logger.Println(err)
 		return 0, errors.New("create get request for polygon")
 	}
 
-	resp, err := new(http.Client).Do(req)
+	resp, err := deps.HTTPClient.Do(req)
 	if err != nil {
-		log.Println(err)
+		deps.Logger.Println(err)
 		return 0, errors.New("get request unsuccessful")
 	}
 
@@ -59,11 +57,11 @@ func Polygon() (int, error) {
 	sort.Slice(votingPowers, func(i, j int) bool { return votingPowers[i] > votingPowers[j] })
 
 	totalVotingPower := utils.CalculateTotalVotingPower(votingPowers)
-	fmt.Println("Total voting power:", totalVotingPower)
+	deps.Logger.Println("Total voting power:", totalVotingPower)
 
 	// Now we're ready to calculate the nakamoto coefficient
 	nakamotoCoefficient := utils.CalcNakamotoCoefficient(totalVotingPower, votingPowers)
-	fmt.Println("The Nakamoto coefficient for 0xPolygon is", nakamotoCoefficient)
+	deps.Logger.Println("The Nakamoto coefficient for 0xPolygon is", nakamotoCoefficient)
 
 	return nakamotoCoefficient, nil
 }
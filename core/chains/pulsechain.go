@@ -1,6 +1,7 @@
 package chains
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -10,30 +11,21 @@ import (
 )
 
 type ApiResponse struct {
-	LastUpdated  string    `json:"last_updated"`
-	Validators   []int64   `json:"active_validator_balances"`
+	LastUpdated string  `json:"last_updated"`
+	Validators  []int64 `json:"active_validator_balances"`
 }
 
 type ApiErrorResponse struct {
-	Code         int       `json:"code"`
-	Message      string    `json:"message"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
 }
 
-func Pulsechain() (int, error) {
-	url := fmt.Sprintf("https://api.korkey.tech/pulsechain/validator_data.json")
-	resp, err := http.Get(url)
+func Pulsechain(ctx context.Context, deps Deps) (int, error) {
+	url := deps.Endpoint("pulsechain", "https://api.korkey.tech") + "/pulsechain/validator_data.json"
+	resp, err := deps.Get(ctx, url)
 	if err != nil {
-		errBody, _ := ioutil.ReadAll(resp.Body)
-		var errResp ApiErrorResponse
-		
-		errr := json.Unmarshal(errBody, &errResp)
-		if errr != nil {
-			return 0, errr
-		}
-
 		return 0, err
 	}
-
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
@@ -41,6 +33,15 @@ func Pulsechain() (int, error) {
 		return 0, err
 	}
 
+	if resp.StatusCode != http.StatusOK {
+		var errResp ApiErrorResponse
+		if json.Unmarshal(body, &errResp) == nil && errResp.Message != "" {
+			return 0, fmt.Errorf("pulsechain returned status %d: %s", resp.StatusCode, errResp.Message)
+		}
+
+		return 0, fmt.Errorf("pulsechain returned status %d", resp.StatusCode)
+	}
+
 	var response ApiResponse
 	err = json.Unmarshal(body, &response)
 	if err != nil {
@@ -53,11 +54,11 @@ func Pulsechain() (int, error) {
 	}
 
 	totalStaked := utils.CalculateTotalVotingPower(response.Validators)
-	fmt.Println("Total voting power:", totalStaked)
+	deps.Logger.Println("Total voting power:", totalStaked)
 
 	// Now we're ready to calculate the nakamoto coefficient
 	nakamotoCoefficient := utils.CalcNakamotoCoefficient(totalStaked, response.Validators)
-	fmt.Println("The Nakamoto coefficient for Pulsechain is", nakamotoCoefficient)
+	deps.Logger.Println("The Nakamoto coefficient for Pulsechain is", nakamotoCoefficient)
 
 	return nakamotoCoefficient, nil
 }
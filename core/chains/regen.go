@@ -1,8 +1,11 @@
 package chains
 
-func Regen() (int, error) {
-	validatorURL := "https://regen.api.m.stavr.tech/cosmos/staking/v1beta1/validators?page.offset=1&pagination.limit=100&status=BOND_STATUS_BONDED"
-	poolURL := "https://regen.api.m.stavr.tech/cosmos/staking/v1beta1/pool"
+import "context"
 
-	return FetchCosmosSDKNakaCoeff("regen", validatorURL, poolURL)
+func Regen(ctx context.Context, deps Deps) (Report, error) {
+	baseURL := deps.Endpoint("regen", "https://regen.api.m.stavr.tech")
+	validatorURL := baseURL + "/cosmos/staking/v1beta1/validators?page.offset=1&pagination.limit=100&status=BOND_STATUS_BONDED"
+	poolURL := baseURL + "/cosmos/staking/v1beta1/pool"
+
+	return FetchCosmosSDKNakaCoeff(ctx, deps, "regen", validatorURL, poolURL)
 }
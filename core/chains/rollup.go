@@ -0,0 +1,547 @@
+package chains
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/abi"
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/jsonrpc"
+	utils "github.com/xenowits/nakamoto-coefficient-calculator/core/utils"
+)
+
+const (
+	// EthereumRPC is the default L1 endpoint used to inspect rollup contracts.
+	EthereumRPC = "https://ethereum-rpc.publicnode.com"
+
+	// Nested Safes deeper than this are counted as a single key.
+	maxSafeDepth = 4
+
+	// defaultRollupBlockWindow is the number of recent L1 blocks scanned for batches, about 20
+	// minutes of Ethereum blocks.
+	defaultRollupBlockWindow = 100
+	// rollupBlockBatchSize is the number of blocks read per JSON-RPC batch.
+	rollupBlockBatchSize = 20
+
+	selectorSafeGetThreshold = "e75235b8"
+	selectorSafeGetOwners    = "a0e67e2b"
+	selectorOwner            = "8da5cb5b"
+
+	// OP Stack L1 contracts.
+	selectorBatcherHash        = "e81b2c6d"
+	selectorBatchInbox         = "dac6e63a"
+	selectorOptimismPortal     = "0a49cb03"
+	selectorDisputeGameFactory = "f2b4e617"
+	selectorRespectedGameType  = "3c9f397c"
+	selectorGameImpls          = "1b685b9e"
+	selectorChallenger         = "534db0e2"
+	selectorProposer           = "a8e4fb90"
+	selectorGuardian           = "452a9320"
+	// permissionedGameType is the dispute game type restricted to a proposer and a challenger.
+	permissionedGameType = 1
+
+	// ArbOS precompiles of Arbitrum chains.
+	arbAggregatorPrecompile   = "0x000000000000000000000000000000000000006d"
+	arbOwnerPublicPrecompile  = "0x000000000000000000000000000000000000006b"
+	selectorGetBatchPosters   = "e10573a3"
+	selectorGetAllChainOwners = "516b4e0f"
+
+	// eip1967AdminSlot is the storage slot holding the admin of an EIP-1967 proxy.
+	eip1967AdminSlot = "0xb53127684a568b3173ae13b9f8a6016e243e63b6e8ee1178d6a717850b5d6103"
+)
+
+// errNoResult is returned by ethCallUint when a call succeeds without return data.
+var errNoResult = errors.New("empty result")
+
+// rollupRole is a role of a rollup, held by addresses that can each exercise it on their own.
+type rollupRole struct {
+	addresses []string
+	// rpcURL is the endpoint of the chain the addresses live on.
+	rpcURL string
+	// source describes where the addresses were read from.
+	source string
+}
+
+// l1Transaction is a transaction of an L1 block read with full transaction objects.
+type l1Transaction struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type l1Block struct {
+	Transactions []l1Transaction `json:"transactions"`
+}
+
+// rollupControl discovers the addresses holding each role of a rollup.
+type rollupControl interface {
+	// batchInbox returns the L1 address the batches of the rollup are sent to.
+	batchInbox(ctx context.Context) (rollupRole, error)
+	// batchSubmitters returns the addresses whose batches are accepted on L1.
+	batchSubmitters(ctx context.Context) (rollupRole, error)
+	// challengers returns the addresses that can overrule state proposals.
+	challengers(ctx context.Context) (rollupRole, error)
+	// proposers returns the addresses allowed to propose state, or no addresses if anyone can.
+	proposers(ctx context.Context) (rollupRole, error)
+	// upgradeOwners returns the addresses that can upgrade the rollup.
+	upgradeOwners(ctx context.Context) (rollupRole, error)
+}
+
+// measureRollup reports the Nakamoto coefficient of a rollup along each control dimension that
+// can be measured, and uses the weakest one as the headline value:
+//   - sequencer: batch submitters weighted by the batches they posted to the L1 inbox over the last
+//     "<name>_block_window" L1 blocks, counting only submitters whose batches are accepted,
+//   - challenger: keys that must collude to overrule state proposals,
+//   - proposer: keys that must collude to act as the permissioned proposer,
+//   - upgrade: keys that must collude to upgrade the rollup.
+//
+// The addresses of each dimension are discovered through control, and can be overridden on L1
+// with the <name>_batch_inbox, <name>_challengers, <name>_proposers and <name>_upgrade_owners
+// settings. Dimensions that cannot be discovered are logged and left out, as is the proposer when
+// anyone can propose.
+func measureRollup(ctx context.Context, deps Deps, name string, control rollupControl) (Report, error) {
+	window := deps.SettingInt(name+"_block_window", defaultRollupBlockWindow)
+	if window <= 0 {
+		return Report{}, fmt.Errorf("%s block window must be positive, got %d", name, window)
+	}
+
+	l1RPC := deps.Endpoint("ethereum_rpc", EthereumRPC)
+	overridden := func(setting string) rollupRole {
+		return rollupRole{addresses: deps.SettingList(setting), rpcURL: l1RPC, source: "any of the configured L1 addresses"}
+	}
+
+	var metrics []Metric
+	if sequencer, err := sequencerMetric(ctx, deps, name, window, overridden(name+"_batch_inbox"), control); err != nil {
+		deps.Logger.Printf("Skipping the sequencer dimension of %s: %v", name, err)
+	} else {
+		metrics = append(metrics, sequencer)
+	}
+
+	dimensions := []struct {
+		name, setting string
+		discover      func(context.Context) (rollupRole, error)
+	}{
+		{"challenger", name + "_challengers", control.challengers},
+		{"proposer", name + "_proposers", control.proposers},
+		{"upgrade", name + "_upgrade_owners", control.upgradeOwners},
+	}
+	for _, dim := range dimensions {
+		role := overridden(dim.setting)
+		if len(role.addresses) == 0 {
+			var err error
+			role, err = dim.discover(ctx)
+			if err != nil {
+				deps.Logger.Printf("Skipping the %s dimension of %s: %v", dim.name, name, err)
+				continue
+			}
+		}
+		if len(role.addresses) == 0 {
+			deps.Logger.Printf("Skipping the %s dimension of %s: %s", dim.name, name, role.source)
+			continue
+		}
+
+		value, err := controllersCoefficient(ctx, deps, role.rpcURL, role.addresses)
+		if err != nil {
+			return Report{}, fmt.Errorf("%s %s: %w", name, dim.name, err)
+		}
+
+		metrics = append(metrics, Metric{
+			Name:        dim.name,
+			Value:       value,
+			Methodology: fmt.Sprintf("keys that must collude to act as %s, resolving Safe multisig thresholds", role.source),
+		})
+	}
+	if len(metrics) == 0 {
+		return Report{}, fmt.Errorf("%s: no control dimension could be measured", name)
+	}
+
+	coefficient := metrics[0].Value
+	for _, m := range metrics {
+		deps.Logger.Printf("The %s Nakamoto coefficient for %s is %d", m.Name, name, m.Value)
+		if m.Value < coefficient {
+			coefficient = m.Value
+		}
+	}
+
+	return Report{Coefficient: coefficient, Metrics: metrics}, nil
+}
+
+// sequencerMetric measures the batch submitters of a rollup by the batches they sent to its L1
+// inbox over the last window L1 blocks. Batches of senders that are not accepted submitters are
+// ignored, as the rollup discards them.
+func sequencerMetric(ctx context.Context, deps Deps, name string, window int, inbox rollupRole, control rollupControl) (Metric, error) {
+	if len(inbox.addresses) == 0 {
+		var err error
+		inbox, err = control.batchInbox(ctx)
+		if err != nil {
+			return Metric{}, fmt.Errorf("batch inbox: %w", err)
+		}
+		if len(inbox.addresses) == 0 {
+			return Metric{}, errors.New(inbox.source)
+		}
+	}
+
+	submitters, err := control.batchSubmitters(ctx)
+	if err != nil {
+		return Metric{}, fmt.Errorf("batch submitters: %w", err)
+	}
+	if len(submitters.addresses) == 0 {
+		return Metric{}, errors.New("no batch submitters found")
+	}
+
+	l1RPC := deps.Endpoint("ethereum_rpc", EthereumRPC)
+	batches, err := countBatches(ctx, deps, l1RPC, inbox.addresses, submitters.addresses, window)
+	if err != nil {
+		return Metric{}, err
+	}
+
+	var counts []int64
+	total := int64(0)
+	for _, count := range batches {
+		counts = append(counts, count)
+		total += count
+	}
+	if total == 0 {
+		return Metric{}, fmt.Errorf("no batches from the %d batch submitters in the last %d L1 blocks", len(submitters.addresses), window)
+	}
+	deps.Logger.Printf("Found %d batches from %d of the %d batch submitters of %s in the last %d L1 blocks",
+		total, len(counts), len(submitters.addresses), name, window)
+
+	return Metric{
+		Name:  "sequencer",
+		Value: utils.CalcNakamotoCoefficient(total, counts),
+		Methodology: fmt.Sprintf("%d batches sent to %s over the last %d L1 blocks by %s, weighted by batches, 33%% threshold",
+			total, inbox.source, window, submitters.source),
+	}, nil
+}
+
+// countBatches returns the number of transactions sent to any of inboxes by each of submitters over
+// the last window blocks of the chain at rpcURL, reading the blocks in batches.
+func countBatches(ctx context.Context, deps Deps, rpcURL string, inboxes, submitters []string, window int) (map[string]int64, error) {
+	var latestHex string
+	if err := deps.RPC(rpcURL).Call(ctx, "eth_blockNumber", []interface{}{}, &latestHex); err != nil {
+		return nil, err
+	}
+	latest, ok := new(big.Int).SetString(strings.TrimPrefix(latestHex, "0x"), 16)
+	if !ok {
+		return nil, fmt.Errorf("invalid block number %q", latestHex)
+	}
+
+	isInbox := make(map[string]bool, len(inboxes))
+	for _, addr := range inboxes {
+		isInbox[strings.ToLower(addr)] = true
+	}
+	batches := make(map[string]int64, len(submitters))
+	for _, addr := range submitters {
+		batches[strings.ToLower(addr)] = 0
+	}
+
+	first := new(big.Int).Sub(latest, big.NewInt(int64(window-1)))
+	if first.Sign() < 0 {
+		first.SetInt64(0)
+	}
+	for start := new(big.Int).Set(first); start.Cmp(latest) <= 0; start.Add(start, big.NewInt(rollupBlockBatchSize)) {
+		var (
+			numbers  []string
+			requests []jsonrpc.Request
+		)
+		for n := new(big.Int).Set(start); n.Cmp(latest) <= 0 && len(requests) < rollupBlockBatchSize; n.Add(n, big.NewInt(1)) {
+			number := fmt.Sprintf("0x%x", n)
+			numbers = append(numbers, number)
+			requests = append(requests, jsonrpc.Request{Method: "eth_getBlockByNumber", Params: []interface{}{number, true}})
+		}
+
+		responses, err := deps.RPC(rpcURL).Batch(ctx, requests)
+		if err != nil {
+			return nil, fmt.Errorf("blocks from %s: %w", start, err)
+		}
+		for i, resp := range responses {
+			var block l1Block
+			if err := resp.Decode(&block); err != nil {
+				return nil, fmt.Errorf("block %s: %w", numbers[i], err)
+			}
+
+			for _, tx := range block.Transactions {
+				from := strings.ToLower(tx.From)
+				if _, accepted := batches[from]; accepted && isInbox[strings.ToLower(tx.To)] {
+					batches[from]++
+				}
+			}
+		}
+	}
+
+	return batches, nil
+}
+
+// opStackControl reads the roles of an OP Stack rollup from its L1 SystemConfig contract.
+type opStackControl struct {
+	deps         Deps
+	l1RPC        string
+	systemConfig string
+}
+
+func (o opStackControl) batchInbox(ctx context.Context) (rollupRole, error) {
+	inbox, err := ethCallAddress(ctx, o.deps, o.l1RPC, o.systemConfig, selectorBatchInbox)
+	if err != nil {
+		return rollupRole{}, err
+	}
+
+	return rollupRole{addresses: []string{inbox}, rpcURL: o.l1RPC, source: "the batch inbox of the L1 SystemConfig"}, nil
+}
+
+func (o opStackControl) batchSubmitters(ctx context.Context) (rollupRole, error) {
+	// The batcher hash is the batcher address left padded to 32 bytes.
+	batcher, err := ethCallAddress(ctx, o.deps, o.l1RPC, o.systemConfig, selectorBatcherHash)
+	if err != nil {
+		return rollupRole{}, fmt.Errorf("batcher: %w", err)
+	}
+
+	return rollupRole{addresses: []string{batcher}, rpcURL: o.l1RPC, source: "the batcher of the L1 SystemConfig"}, nil
+}
+
+func (o opStackControl) challengers(ctx context.Context) (rollupRole, error) {
+	permissioned, portal, err := o.permissioned(ctx)
+	if err != nil {
+		return rollupRole{}, err
+	}
+	if permissioned {
+		return o.permissionedRole(ctx, selectorChallenger, "the challenger of the permissioned dispute game")
+	}
+
+	// With permissionless proofs, the guardian can still blacklist dispute games.
+	guardian, err := ethCallAddress(ctx, o.deps, o.l1RPC, portal, selectorGuardian)
+	if err != nil {
+		return rollupRole{}, fmt.Errorf("guardian: %w", err)
+	}
+
+	return rollupRole{addresses: []string{guardian}, rpcURL: o.l1RPC, source: "the guardian of the OptimismPortal, which can blacklist dispute games"}, nil
+}
+
+func (o opStackControl) proposers(ctx context.Context) (rollupRole, error) {
+	permissioned, _, err := o.permissioned(ctx)
+	if err != nil {
+		return rollupRole{}, err
+	}
+	if !permissioned {
+		return rollupRole{source: "anyone can propose with permissionless dispute games"}, nil
+	}
+
+	return o.permissionedRole(ctx, selectorProposer, "the proposer of the permissioned dispute game")
+}
+
+func (o opStackControl) upgradeOwners(ctx context.Context) (rollupRole, error) {
+	var slot string
+	params := []interface{}{o.systemConfig, eip1967AdminSlot, "latest"}
+	if err := o.deps.RPC(o.l1RPC).Call(ctx, "eth_getStorageAt", params, &slot); err != nil {
+		return rollupRole{}, fmt.Errorf("proxy admin: %w", err)
+	}
+	data, err := abi.Decode(slot)
+	if err != nil {
+		return rollupRole{}, fmt.Errorf("proxy admin: %w", err)
+	}
+	admin, err := data.Address(0)
+	if err != nil {
+		return rollupRole{}, fmt.Errorf("proxy admin: %w", err)
+	}
+
+	// The admin is a ProxyAdmin contract, whose owner performs the upgrades.
+	owner, err := ethCallAddress(ctx, o.deps, o.l1RPC, admin, selectorOwner)
+	if err != nil {
+		return rollupRole{}, fmt.Errorf("proxy admin %s owner: %w", admin, err)
+	}
+
+	return rollupRole{addresses: []string{owner}, rpcURL: o.l1RPC, source: "the owner of the L1 ProxyAdmin"}, nil
+}
+
+// permissioned reports whether the OptimismPortal only respects permissioned dispute games, along
+// with the address of the portal.
+func (o opStackControl) permissioned(ctx context.Context) (bool, string, error) {
+	portal, err := ethCallAddress(ctx, o.deps, o.l1RPC, o.systemConfig, selectorOptimismPortal)
+	if err != nil {
+		return false, "", fmt.Errorf("optimism portal: %w", err)
+	}
+
+	gameType, err := ethCallUint(ctx, o.deps, o.l1RPC, portal, selectorRespectedGameType)
+	if err != nil {
+		return false, "", fmt.Errorf("respected game type: %w", err)
+	}
+
+	return gameType.Cmp(big.NewInt(permissionedGameType)) == 0, portal, nil
+}
+
+// permissionedRole returns the address returned by selector on the permissioned dispute game.
+func (o opStackControl) permissionedRole(ctx context.Context, selector, source string) (rollupRole, error) {
+	factory, err := ethCallAddress(ctx, o.deps, o.l1RPC, o.systemConfig, selectorDisputeGameFactory)
+	if err != nil {
+		return rollupRole{}, fmt.Errorf("dispute game factory: %w", err)
+	}
+
+	data, err := ethCallData(ctx, o.deps, o.l1RPC, factory, selectorGameImpls, big.NewInt(permissionedGameType))
+	if err != nil {
+		return rollupRole{}, fmt.Errorf("permissioned dispute game: %w", err)
+	}
+	game, err := data.Address(0)
+	if err != nil {
+		return rollupRole{}, fmt.Errorf("permissioned dispute game: %w", err)
+	}
+
+	addr, err := ethCallAddress(ctx, o.deps, o.l1RPC, game, selector)
+	if err != nil {
+		return rollupRole{}, fmt.Errorf("permissioned dispute game %s: %w", game, err)
+	}
+
+	return rollupRole{addresses: []string{addr}, rpcURL: o.l1RPC, source: source}, nil
+}
+
+// arbitrumControl reads the roles of an Arbitrum chain from the ArbOS precompiles on the chain
+// itself. The precompiles do not know the SequencerInbox on L1, so the sequencer is only measured
+// when it is configured. The challenger and proposer roles are held by the validators of the
+// rollup contract on L1, which cannot be enumerated, so they are only measured when configured.
+type arbitrumControl struct {
+	deps  Deps
+	l2RPC string
+}
+
+func (a arbitrumControl) batchInbox(context.Context) (rollupRole, error) {
+	return rollupRole{source: "the SequencerInbox on L1 is not configured"}, nil
+}
+
+func (a arbitrumControl) batchSubmitters(ctx context.Context) (rollupRole, error) {
+	posters, err := ethCallAddressArray(ctx, a.deps, a.l2RPC, arbAggregatorPrecompile, selectorGetBatchPosters)
+	if err != nil {
+		return rollupRole{}, fmt.Errorf("batch posters: %w", err)
+	}
+
+	return rollupRole{addresses: posters, rpcURL: a.l2RPC, source: "the batch posters registered with ArbAggregator"}, nil
+}
+
+func (a arbitrumControl) challengers(context.Context) (rollupRole, error) {
+	return rollupRole{source: "the rollup validators on L1 are not configured"}, nil
+}
+
+func (a arbitrumControl) proposers(context.Context) (rollupRole, error) {
+	return rollupRole{source: "the rollup validators on L1 are not configured"}, nil
+}
+
+func (a arbitrumControl) upgradeOwners(ctx context.Context) (rollupRole, error) {
+	owners, err := ethCallAddressArray(ctx, a.deps, a.l2RPC, arbOwnerPublicPrecompile, selectorGetAllChainOwners)
+	if err != nil {
+		return rollupRole{}, fmt.Errorf("chain owners: %w", err)
+	}
+
+	return rollupRole{addresses: owners, rpcURL: a.l2RPC, source: "any of the chain owners of ArbOS, which can upgrade the chain"}, nil
+}
+
+// controllersCoefficient returns the coefficient of a role held by any of the given addresses,
+// which is that of the address needing the fewest colluding keys.
+func controllersCoefficient(ctx context.Context, deps Deps, rpcURL string, addresses []string) (int, error) {
+	coefficient := 0
+	for _, addr := range addresses {
+		c, err := safeCoefficient(ctx, deps, rpcURL, addr, 0)
+		if err != nil {
+			return 0, fmt.Errorf("address %s: %w", addr, err)
+		}
+		if coefficient == 0 || c < coefficient {
+			coefficient = c
+		}
+	}
+
+	return coefficient, nil
+}
+
+// safeCoefficient returns the number of independent keys that must collude to act as addr.
+// Accounts without code and contracts that are not Safes count as a single key. For a Safe with
+// threshold k, it is the sum of the k smallest coefficients of its owners.
+func safeCoefficient(ctx context.Context, deps Deps, rpcURL, addr string, depth int) (int, error) {
+	if depth >= maxSafeDepth {
+		return 1, nil
+	}
+
+	var code string
+	if err := deps.RPC(rpcURL).Call(ctx, "eth_getCode", []interface{}{addr, "latest"}, &code); err != nil {
+		return 0, err
+	}
+	if code == "" || code == "0x" {
+		return 1, nil
+	}
+
+	// Contracts that are not Safes revert or return nothing.
+	var rpcErr *jsonrpc.Error
+	threshold, err := ethCallUint(ctx, deps, rpcURL, addr, selectorSafeGetThreshold)
+	if errors.As(err, &rpcErr) || errors.Is(err, errNoResult) {
+		return 1, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	owners, err := ethCallAddressArray(ctx, deps, rpcURL, addr, selectorSafeGetOwners)
+	if err != nil {
+		return 0, fmt.Errorf("getOwners: %w", err)
+	}
+	if threshold.Sign() <= 0 || threshold.Cmp(big.NewInt(int64(len(owners)))) > 0 {
+		return 0, fmt.Errorf("invalid safe threshold %s for %d owners", threshold, len(owners))
+	}
+
+	var ownerCoefficients []int
+	for _, owner := range owners {
+		c, err := safeCoefficient(ctx, deps, rpcURL, owner, depth+1)
+		if err != nil {
+			return 0, fmt.Errorf("owner %s: %w", owner, err)
+		}
+		ownerCoefficients = append(ownerCoefficients, c)
+	}
+	sort.Ints(ownerCoefficients)
+
+	total := 0
+	for _, c := range ownerCoefficients[:threshold.Int64()] {
+		total += c
+	}
+
+	return total, nil
+}
+
+func ethCallUint(ctx context.Context, deps Deps, rpcURL, to, selector string) (*big.Int, error) {
+	data, err := ethCallData(ctx, deps, rpcURL, to, selector)
+	if err != nil {
+		return nil, err
+	}
+	if data.Words() == 0 {
+		return nil, fmt.Errorf("selector %s: %w", selector, errNoResult)
+	}
+
+	return data.Uint(0)
+}
+
+func ethCallAddress(ctx context.Context, deps Deps, rpcURL, to, selector string) (string, error) {
+	data, err := ethCallData(ctx, deps, rpcURL, to, selector)
+	if err != nil {
+		return "", err
+	}
+	if data.Words() == 0 {
+		return "", fmt.Errorf("selector %s: %w", selector, errNoResult)
+	}
+
+	return data.Address(0)
+}
+
+// ethCallAddressArray decodes the address[] returned by a call, such as Safe.getOwners().
+func ethCallAddressArray(ctx context.Context, deps Deps, rpcURL, to, selector string) ([]string, error) {
+	data, err := ethCallData(ctx, deps, rpcURL, to, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	return data.AddressArray(0)
+}
+
+func ethCallData(ctx context.Context, deps Deps, rpcURL, to, selector string, args ...*big.Int) (abi.Data, error) {
+	var res string
+	call := map[string]string{"to": to, "data": abi.EncodeCall(selector, args...)}
+	if err := deps.RPC(rpcURL).Call(ctx, "eth_call", []interface{}{call, "latest"}, &res); err != nil {
+		return nil, err
+	}
+
+	return abi.Decode(res)
+}
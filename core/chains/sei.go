@@ -1,8 +1,11 @@
 package chains
 
-func Sei() (int, error) {
-	validatorsURL := "https://rest.sei-apis.com/cosmos/staking/v1beta1/validators?page.offset=1&pagination.limit=100&status=BOND_STATUS_BONDED"
-	stakingPoolURL := "https://rest.sei-apis.com/cosmos/staking/v1beta1/pool"
+import "context"
 
-	return FetchCosmosSDKNakaCoeff("sei", validatorsURL, stakingPoolURL)
+func Sei(ctx context.Context, deps Deps) (Report, error) {
+	baseURL := deps.Endpoint("sei", "https://rest.sei-apis.com")
+	validatorsURL := baseURL + "/cosmos/staking/v1beta1/validators?page.offset=1&pagination.limit=100&status=BOND_STATUS_BONDED"
+	stakingPoolURL := baseURL + "/cosmos/staking/v1beta1/pool"
+
+	return FetchCosmosSDKNakaCoeff(ctx, deps, "sei", validatorsURL, stakingPoolURL)
 }
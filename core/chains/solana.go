@@ -1,77 +1,91 @@
 package chains
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
-	"log"
 	"math/big"
-	"net/http"
-	"os"
 	"sort"
 
 	utils "github.com/xenowits/nakamoto-coefficient-calculator/core/utils"
 )
 
-type SolanaResponse []struct {
-	Name         string `json:"name"`
-	Account      string `json:"keybase_id"`
-	Active_stake int64  `json:"active_stake"`
-	Delinquent   bool   `json:"delinquent"`
-}
+const lamportsPerSOL = 1_000_000_000
 
-func Solana() (int, error) {
-	url := fmt.Sprintf("https://www.validators.app/api/v1/validators/mainnet.json")
+type SolanaVoteAccount struct {
+	VotePubkey     string `json:"votePubkey"`
+	NodePubkey     string `json:"nodePubkey"`
+	ActivatedStake uint64 `json:"activatedStake"`
+	EpochVoteAcct  bool   `json:"epochVoteAccount"`
+}
 
-	var votingPowers []big.Int
+type SolanaVoteAccounts struct {
+	Current    []SolanaVoteAccount `json:"current"`
+	Delinquent []SolanaVoteAccount `json:"delinquent"`
+}
 
-	// Create a new request using http
-	req, err := http.NewRequest("GET", url, nil)
+type SolanaEpochInfo struct {
+	Epoch        uint64 `json:"epoch"`
+	AbsoluteSlot uint64 `json:"absoluteSlot"`
+}
 
-	// Add authorization header to the request
-	// NOTE: You can get your own API_KEY from https://www.validators.app/api-documentation
-	req.Header.Add("Token", os.Getenv("SOLANA_API_KEY"))
+// Solana calculates the Nakamoto coefficient over the activated stake of current vote accounts as
+// reported by getVoteAccounts. Delinquent vote accounts are excluded and reported as metrics.
+func Solana(ctx context.Context, deps Deps) (Report, error) {
+	url := deps.Endpoint("solana", "https://api.mainnet-beta.solana.com")
 
-	// Send req using http Client
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Println(err)
-		return 0, err
+	var epoch SolanaEpochInfo
+	if err := deps.RPC(url).Call(ctx, "getEpochInfo", []interface{}{}, &epoch); err != nil {
+		return Report{}, fmt.Errorf("solana epoch info: %w", err)
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return 0, err
+	var accounts SolanaVoteAccounts
+	params := []interface{}{map[string]string{"commitment": "finalized"}}
+	if err := deps.RPC(url).Call(ctx, "getVoteAccounts", params, &accounts); err != nil {
+		return Report{}, fmt.Errorf("solana vote accounts: %w", err)
 	}
 
-	var response SolanaResponse
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		return 0, err
+	var votingPowers []big.Int
+	for _, acc := range accounts.Current {
+		if acc.ActivatedStake == 0 {
+			continue
+		}
+		votingPowers = append(votingPowers, *new(big.Int).SetUint64(acc.ActivatedStake))
+	}
+	if len(votingPowers) == 0 {
+		return Report{}, fmt.Errorf("no current vote accounts with activated stake")
 	}
 
-	// loop through the validators voting powers
-	for _, ele := range response {
-		votingPowers = append(votingPowers, *big.NewInt(ele.Active_stake))
+	delinquentStake := new(big.Int)
+	for _, acc := range accounts.Delinquent {
+		delinquentStake.Add(delinquentStake, new(big.Int).SetUint64(acc.ActivatedStake))
 	}
 
 	// need to sort the powers in descending order since they are in random order
 	sort.Slice(votingPowers, func(i, j int) bool {
-		res := (&votingPowers[i]).Cmp(&votingPowers[j])
-		if res == 1 {
-			return true
-		}
-		return false
+		return (&votingPowers[i]).Cmp(&votingPowers[j]) > 0
 	})
 
 	totalVotingPower := utils.CalculateTotalVotingPowerBigNums(votingPowers)
-	fmt.Println("Total voting power:", new(big.Float).SetInt(totalVotingPower))
+	deps.Logger.Printf("Solana epoch %d: %d current vote accounts with %s lamports, %d delinquent with %s lamports",
+		epoch.Epoch, len(votingPowers), totalVotingPower, len(accounts.Delinquent), delinquentStake)
 
 	// now we're ready to calculate the nakomoto coefficient
 	nakamotoCoefficient := utils.CalcNakamotoCoefficientBigNums(totalVotingPower, votingPowers)
-	fmt.Println("The Nakamoto coefficient for Solana is", nakamotoCoefficient)
+	deps.Logger.Println("The Nakamoto coefficient for Solana is", nakamotoCoefficient)
 
-	return nakamotoCoefficient, nil
+	return Report{
+		Coefficient: nakamotoCoefficient,
+		Metrics: []Metric{
+			{
+				Name:        "delinquent_validators",
+				Value:       len(accounts.Delinquent),
+				Methodology: fmt.Sprintf("delinquent vote accounts in epoch %d, excluded from the coefficient", epoch.Epoch),
+			},
+			{
+				Name:        "delinquent_stake_sol",
+				Value:       int(new(big.Int).Div(delinquentStake, big.NewInt(lamportsPerSOL)).Int64()),
+				Methodology: fmt.Sprintf("activated stake of delinquent vote accounts in epoch %d, in SOL", epoch.Epoch),
+			},
+		},
+	}, nil
 }
@@ -1,8 +1,11 @@
 package chains
 
-func Stargaze() (int, error) {
-	validatorsURL := "https://rest.stargaze-apis.com/cosmos/staking/v1beta1/validators?page.offset=1&pagination.limit=500&status=BOND_STATUS_BONDED"
-	stakingPoolURL := "https://rest.stargaze-apis.com/cosmos/staking/v1beta1/pool"
+import "context"
 
-	return FetchCosmosSDKNakaCoeff("stargaze", validatorsURL, stakingPoolURL)
+func Stargaze(ctx context.Context, deps Deps) (Report, error) {
+	baseURL := deps.Endpoint("stargaze", "https://rest.stargaze-apis.com")
+	validatorsURL := baseURL + "/cosmos/staking/v1beta1/validators?page.offset=1&pagination.limit=500&status=BOND_STATUS_BONDED"
+	stakingPoolURL := baseURL + "/cosmos/staking/v1beta1/pool"
+
+	return FetchCosmosSDKNakaCoeff(ctx, deps, "stargaze", validatorsURL, stakingPoolURL)
 }
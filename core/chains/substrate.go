@@ -0,0 +1,269 @@
+package chains
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/entities"
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/substrate"
+)
+
+const (
+	substrateKeysPageSize  = 1000
+	substrateQueryPageSize = 200
+	substrateAccountIDLen  = 32
+)
+
+// substrateChain describes where to read the staking data of a Substrate based chain.
+type substrateChain struct {
+	name string
+	rpc  string
+	// identityRPC serves the Identity pallet, which may live on a separate system chain.
+	identityRPC string
+	ss58Prefix  uint16
+}
+
+type substrateStorageChangeSet struct {
+	Block   string       `json:"block"`
+	Changes [][2]*string `json:"changes"`
+}
+
+// substrateReport calculates the Nakamoto coefficient over the exposure of the validators active
+// in the current era. Unless the "<name>_identities" setting is "false", validators registered as
+// sub-identities of the same parent are grouped into one entity for the coefficient over entities.
+func substrateReport(ctx context.Context, deps Deps, chain substrateChain) (Report, error) {
+	mapping, err := entities.Load(ctx, deps, deps.Setting(chain.name+"_entities", ""))
+	if err != nil {
+		return Report{}, fmt.Errorf("failed to load entities for %s: %w", chain.name, err)
+	}
+
+	var head string
+	if err := deps.RPC(chain.rpc).Call(ctx, "chain_getFinalizedHead", []interface{}{}, &head); err != nil {
+		return Report{}, fmt.Errorf("%s finalized head: %w", chain.name, err)
+	}
+
+	era, err := substrateActiveEra(ctx, deps, chain.rpc, head)
+	if err != nil {
+		return Report{}, fmt.Errorf("%s active era: %w", chain.name, err)
+	}
+
+	validators, err := substrateExposures(ctx, deps, chain.rpc, head, era)
+	if err != nil {
+		return Report{}, fmt.Errorf("%s era %d exposures: %w", chain.name, era, err)
+	}
+	if len(validators) == 0 {
+		return Report{}, fmt.Errorf("no %s validators found in era %d", chain.name, era)
+	}
+
+	if deps.Setting(chain.name+"_identities", "true") != "false" {
+		parents, err := substrateParents(ctx, deps, chain.identityRPC, validators)
+		if err != nil {
+			return Report{}, fmt.Errorf("%s identities: %w", chain.name, err)
+		}
+
+		for i := range validators {
+			id := validators[i].Address
+			if parent, ok := parents[id]; ok {
+				id = parent
+			}
+			validators[i].Identity = substrate.SS58Encode(mustHex(id), chain.ss58Prefix)
+		}
+	}
+
+	for i := range validators {
+		validators[i].Address = substrate.SS58Encode(mustHex(validators[i].Address), chain.ss58Prefix)
+	}
+
+	coefficients := entities.Calculate(validators, mapping, nil)
+	deps.Logger.Printf("The Nakamoto coefficient for %s in era %d is %d, %d over %d entities",
+		chain.name, era, coefficients.Validators, coefficients.Entities, coefficients.EntityCount)
+
+	return entityReport(coefficients), nil
+}
+
+// substrateActiveEra reads Staking.ActiveEra, whose value starts with the u32 era index.
+func substrateActiveEra(ctx context.Context, deps Deps, rpcURL, at string) (uint32, error) {
+	var value *string
+	key := hexKey(substrate.StorageKey("Staking", "ActiveEra"))
+	if err := deps.RPC(rpcURL).Call(ctx, "state_getStorage", []interface{}{key, at}, &value); err != nil {
+		return 0, err
+	}
+	if value == nil {
+		return 0, errors.New("no active era")
+	}
+
+	b, err := hex.DecodeString(strings.TrimPrefix(*value, "0x"))
+	if err != nil || len(b) < 4 {
+		return 0, fmt.Errorf("invalid active era %q", *value)
+	}
+
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+// substrateExposures returns the total exposure of each validator of era, addressed by the hex
+// encoded account ID. The paged Staking.ErasStakersOverview is read, falling back to the legacy
+// Staking.ErasStakers on runtimes without paged exposures. Both values start with the compact
+// encoded total.
+func substrateExposures(ctx context.Context, deps Deps, rpcURL, at string, era uint32) ([]entities.Validator, error) {
+	eraKey := make([]byte, 4)
+	binary.LittleEndian.PutUint32(eraKey, era)
+
+	var validators []entities.Validator
+	for _, item := range []string{"ErasStakersOverview", "ErasStakers"} {
+		prefix := substrate.StorageKey("Staking", item, substrate.Twox64Concat(eraKey))
+
+		keys, err := substrateKeys(ctx, deps, rpcURL, at, prefix)
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) == 0 {
+			continue
+		}
+
+		values, err := substrateQuery(ctx, deps, rpcURL, at, keys)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range keys {
+			value, ok := values[key]
+			if !ok {
+				continue
+			}
+			// The key ends with Twox64Concat(account ID): an 8 byte hash and the 32 byte ID.
+			if len(key) != 2*(len(prefix)+8+substrateAccountIDLen) {
+				return nil, fmt.Errorf("%s key %s too short", item, key)
+			}
+
+			total, _, err := substrate.DecodeCompact(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s value of %s: %w", item, key, err)
+			}
+
+			validators = append(validators, entities.Validator{
+				Address: "0x" + key[len(key)-2*substrateAccountIDLen:],
+				Stake:   total,
+			})
+		}
+
+		deps.Logger.Printf("Read %d exposures from Staking.%s for era %d", len(validators), item, era)
+		break
+	}
+
+	return validators, nil
+}
+
+// substrateParents returns the parent identity of the validators that are sub-identities,
+// both addressed by hex encoded account ID, read from Identity.SuperOf.
+func substrateParents(ctx context.Context, deps Deps, rpcURL string, validators []entities.Validator) (map[string]string, error) {
+	keys := make([]string, 0, len(validators))
+	accounts := make(map[string]string, len(validators))
+	for _, v := range validators {
+		key := hex.EncodeToString(substrate.StorageKey("Identity", "SuperOf", substrate.Blake2_128Concat(mustHex(v.Address))))
+		keys = append(keys, key)
+		accounts[key] = v.Address
+	}
+
+	values, err := substrateQuery(ctx, deps, rpcURL, "", keys)
+	if err != nil {
+		return nil, err
+	}
+
+	parents := make(map[string]string)
+	for key, value := range values {
+		if len(value) < substrateAccountIDLen {
+			return nil, fmt.Errorf("invalid SuperOf value for %s", accounts[key])
+		}
+		parents[accounts[key]] = "0x" + hex.EncodeToString(value[:substrateAccountIDLen])
+	}
+
+	return parents, nil
+}
+
+// substrateKeys lists all storage keys starting with prefix.
+func substrateKeys(ctx context.Context, deps Deps, rpcURL, at string, prefix []byte) ([]string, error) {
+	var (
+		keys  []string
+		start interface{}
+	)
+	for {
+		var page []string
+		params := []interface{}{hexKey(prefix), substrateKeysPageSize, start, at}
+		if err := deps.RPC(rpcURL).Call(ctx, "state_getKeysPaged", params, &page); err != nil {
+			return nil, err
+		}
+
+		for _, key := range page {
+			key = strings.ToLower(strings.TrimPrefix(key, "0x"))
+			if _, err := hex.DecodeString(key); err != nil {
+				return nil, fmt.Errorf("invalid storage key %q", key)
+			}
+			keys = append(keys, key)
+		}
+		if len(page) < substrateKeysPageSize {
+			return keys, nil
+		}
+		start = page[len(page)-1]
+	}
+}
+
+// substrateQuery returns the values of the given hex encoded storage keys that exist. An empty at
+// queries the best block.
+func substrateQuery(ctx context.Context, deps Deps, rpcURL, at string, keys []string) (map[string][]byte, error) {
+	values := make(map[string][]byte, len(keys))
+	for i := 0; i < len(keys); i += substrateQueryPageSize {
+		end := i + substrateQueryPageSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		page := make([]string, 0, end-i)
+		for _, key := range keys[i:end] {
+			page = append(page, "0x"+key)
+		}
+
+		params := []interface{}{page}
+		if at != "" {
+			params = append(params, at)
+		}
+
+		var changeSets []substrateStorageChangeSet
+		if err := deps.RPC(rpcURL).Call(ctx, "state_queryStorageAt", params, &changeSets); err != nil {
+			return nil, err
+		}
+
+		for _, set := range changeSets {
+			for _, change := range set.Changes {
+				if change[0] == nil || change[1] == nil {
+					continue
+				}
+
+				value, err := hex.DecodeString(strings.TrimPrefix(*change[1], "0x"))
+				if err != nil {
+					return nil, fmt.Errorf("invalid storage value for %s", *change[0])
+				}
+				values[strings.ToLower(strings.TrimPrefix(*change[0], "0x"))] = value
+			}
+		}
+	}
+
+	return values, nil
+}
+
+func hexKey(key []byte) string {
+	return "0x" + hex.EncodeToString(key)
+}
+
+// mustHex decodes a 0x prefixed hex string that has already been validated.
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}
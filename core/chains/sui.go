@@ -1,123 +1,133 @@
 package chains
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
-	"log"
 	"math/big"
-	"net/http"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	utils "github.com/xenowits/nakamoto-coefficient-calculator/core/utils"
 )
 
-type SuiResponse struct {
-	Result struct {
-		ActiveValidators []struct {
-			VotingPower string `json:"votingPower"`
-		} `json:"activeValidators"`
-	} `json:"result"`
+// suiTotalVotingPower is the voting power shared by the active validators, 10000 being 100%.
+const suiTotalVotingPower = 10_000
+
+type SuiValidator struct {
+	SuiAddress            string `json:"suiAddress"`
+	Name                  string `json:"name"`
+	VotingPower           string `json:"votingPower"`
+	StakingPoolSuiBalance string `json:"stakingPoolSuiBalance"`
 }
 
-type rawBody struct {
-	JSONRPC string        `json:"jsonrpc"`
-	ID      int           `json:"id"`
-	Method  string        `json:"method"`
-	Params  []interface{} `json:"params"`
+type SuiSystemState struct {
+	Epoch            string         `json:"epoch"`
+	TotalStake       string         `json:"totalStake"`
+	ActiveValidators []SuiValidator `json:"activeValidators"`
 }
 
-func Sui() (int, error) {
-	request := rawBody{
-		JSONRPC: "2.0",
-		ID:      1,
-		Method:  "suix_getLatestSuiSystemState",
-		Params:  []interface{}{},
-	}
+// suiPower is the voting power and staking pool balance of a validator.
+type suiPower struct {
+	validator SuiValidator
+	power     *big.Int
+	balance   *big.Int
+}
 
-	baseURL := "https://fullnode.mainnet.sui.io"
+func Sui(ctx context.Context, deps Deps) (Report, error) {
+	baseURL := deps.Endpoint("sui", "https://fullnode.mainnet.sui.io")
 
-	return fetchDataSUI("sui", baseURL, request)
+	return fetchDataSUI(ctx, deps, "sui", baseURL)
 }
 
 // fetchDataSUI returns the nakamoto coefficient value for SUI by fetching sui validator voting powers
-// and calculating NC value from the data.
-func fetchDataSUI(chainName string, url string, request rawBody) (int, error) {
-	var votingPowers []big.Int
+// and calculating NC value from the data. The report is tagged with the epoch of the system state,
+// and the coefficient over staking pool balances is reported as the "stake" metric.
+func fetchDataSUI(ctx context.Context, deps Deps, chainName string, url string) (Report, error) {
+	var response SuiSystemState
+
+	ctx, cancelFunc := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelFunc()
 
-	response, err := fetchData(url, request)
+	err := deps.RPC(url).Call(ctx, "suix_getLatestSuiSystemState", nil, &response)
 	if err != nil {
-		return 0, fmt.Errorf("failed to fetch data for %s: %w", chainName, err)
+		return Report{}, fmt.Errorf("failed to fetch data for %s: %w", chainName, err)
+	}
+
+	epoch, err := strconv.ParseUint(response.Epoch, 10, 64)
+	if err != nil {
+		return Report{}, fmt.Errorf("invalid %s epoch %q", chainName, response.Epoch)
+	}
+
+	totalStake, ok := new(big.Int).SetString(response.TotalStake, 10)
+	if !ok {
+		return Report{}, fmt.Errorf("invalid %s total stake %q", chainName, response.TotalStake)
 	}
 
-	// Loop through the validators voting powers.
 	// Sui has voting power indicator for each validator.
-	// Total is 10000==100%
-	for _, ele := range response.Result.ActiveValidators {
-		votingPower, err := strconv.ParseInt(ele.VotingPower, 10, 64)
-		if err != nil {
-			log.Println(err)
+	powers := make([]suiPower, 0, len(response.ActiveValidators))
+	totalVotingPower := big.NewInt(0)
+	for _, v := range response.ActiveValidators {
+		power, ok := new(big.Int).SetString(v.VotingPower, 10)
+		if !ok {
+			return Report{}, fmt.Errorf("validator %s: invalid voting power %q", v.SuiAddress, v.VotingPower)
+		}
+		balance, ok := new(big.Int).SetString(v.StakingPoolSuiBalance, 10)
+		if !ok {
+			return Report{}, fmt.Errorf("validator %s: invalid staking pool balance %q", v.SuiAddress, v.StakingPoolSuiBalance)
 		}
 
-		votingPowers = append(votingPowers, *big.NewInt(votingPower))
+		powers = append(powers, suiPower{validator: v, power: power, balance: balance})
+		totalVotingPower.Add(totalVotingPower, power)
+	}
+	if len(powers) == 0 {
+		return Report{}, fmt.Errorf("no active validators found for %s", chainName)
+	}
+
+	// Voting power is normalized to a total of 10000; only fall back to the sum if it exceeds it.
+	if totalVotingPower.Cmp(big.NewInt(suiTotalVotingPower)) != 0 {
+		deps.Logger.Printf("Voting power of %s validators sums to %s instead of %d", chainName, totalVotingPower, suiTotalVotingPower)
+	}
+	if totalVotingPower.Cmp(big.NewInt(suiTotalVotingPower)) < 0 {
+		totalVotingPower.SetInt64(suiTotalVotingPower)
 	}
 
-	sort.Slice(votingPowers, func(i, j int) bool {
-		return votingPowers[i].Cmp(&votingPowers[j]) > 0
+	sort.SliceStable(powers, func(i, j int) bool {
+		return powers[i].power.Cmp(powers[j].power) > 0
 	})
 
-	totalVotingPower := utils.CalculateTotalVotingPowerBigNums(votingPowers)
-	fmt.Printf("Total voting power for %s: %s\n", chainName, new(big.Float).SetInt(totalVotingPower).String())
+	votingPowers := make([]big.Int, 0, len(powers))
+	for _, p := range powers {
+		votingPowers = append(votingPowers, *p.power)
+	}
 
 	// Now we're ready to calculate the nakomoto coefficient.
 	nakamotoCoefficient := utils.CalcNakamotoCoefficientBigNums(totalVotingPower, votingPowers)
-	fmt.Printf("The Nakamoto coefficient for %s is %d\n", chainName, nakamotoCoefficient)
 
-	return nakamotoCoefficient, nil
-}
-
-func fetchData(url string, request rawBody) (SuiResponse, error) {
-	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancelFunc()
-
-	requestBody, err := json.Marshal(request)
-	if err != nil {
-		log.Println(err)
-		return SuiResponse{}, errors.New("failed to marshal request for sui")
-	}
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(requestBody))
-	if err != nil {
-		log.Println(err)
-		return SuiResponse{}, errors.New("create POST request for sui")
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Println(err)
-		return SuiResponse{}, errors.New("POST request unsuccessful for sui")
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return SuiResponse{}, err
+	names := make([]string, 0, nakamotoCoefficient)
+	for _, p := range powers[:nakamotoCoefficient] {
+		names = append(names, fmt.Sprintf("%s (%s)", p.validator.Name, p.validator.SuiAddress))
 	}
+	deps.Logger.Printf("%s epoch %d: %d active validators with %s MIST staked, controlled by %s",
+		chainName, epoch, len(powers), totalStake, strings.Join(names, ", "))
+	deps.Logger.Printf("The Nakamoto coefficient for %s is %d", chainName, nakamotoCoefficient)
 
-	var response SuiResponse
-
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		log.Println(err)
-		return SuiResponse{}, errors.New("failed to unmarshal response for sui")
+	sort.SliceStable(powers, func(i, j int) bool {
+		return powers[i].balance.Cmp(powers[j].balance) > 0
+	})
+	balances := make([]big.Int, 0, len(powers))
+	for _, p := range powers {
+		balances = append(balances, *p.balance)
 	}
 
-	return response, nil
+	return Report{
+		Coefficient: nakamotoCoefficient,
+		Epoch:       epoch,
+		Metrics: []Metric{{
+			Name:        "stake",
+			Value:       utils.CalcNakamotoCoefficientBigNums(totalStake, balances),
+			Methodology: fmt.Sprintf("staking pool balances of active validators against the total stake of epoch %d, 33%% threshold", epoch),
+		}},
+	}, nil
 }
@@ -3,87 +3,184 @@ package chains
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
-	"log"
 	"math/big"
 	"net/http"
-	"sort"
 	"time"
 
-	"github.com/xenowits/nakamoto-coefficient-calculator/core/utils"
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/entities"
 )
 
-type ThorchainResponse []struct {
-	NodeAddress string `json:"node_address"`
-	Bond        string `json:"total_bond"`
-	Status      string `json:"status"`
+type ThorchainBondProvider struct {
+	BondAddress string `json:"bond_address"`
+	Bond        string `json:"bond"`
 }
 
+type ThorchainNode struct {
+	NodeAddress         string `json:"node_address"`
+	NodeOperatorAddress string `json:"node_operator_address"`
+	Bond                string `json:"total_bond"`
+	Status              string `json:"status"`
+	RequestedToLeave    bool   `json:"requested_to_leave"`
+	ForcedToLeave       bool   `json:"forced_to_leave"`
+	BondProviders       struct {
+		Providers []ThorchainBondProvider `json:"providers"`
+	} `json:"bond_providers"`
+	PreflightStatus struct {
+		Status string `json:"status"`
+	} `json:"preflight_status"`
+}
+
+type ThorchainResponse []ThorchainNode
+
 type ThorchainErrorResponse struct {
 	Id      int    `json:"id"`
 	Jsonrpc string `json:"jsonrpc"`
 	Error   string `json:"error"`
 }
 
-func Thorchain() (int, error) {
-	votingPowers := make([]big.Int, 0, 1000)
-	url := fmt.Sprintf("https://thornode.ninerealms.com/thorchain/nodes")
-	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
+// Thorchain calculates the Nakamoto coefficient over the bond of nodes with status Active.
+//
+// Nodes are grouped into entities according to the "thorchain_grouping" setting: "operator"
+// (default) groups nodes by node operator address, "bond_provider" by the provider contributing
+// most of their bond, and "none" disables grouping. The "thorchain_entities" mapping takes
+// precedence over both. Churn-eligible standby nodes are reported in a separate view, as the
+// coefficient over active nodes and the standby nodes that could churn in.
+func Thorchain(ctx context.Context, deps Deps) (Report, error) {
+	url := deps.Endpoint("thorchain", "https://thornode.ninerealms.com") + "/thorchain/nodes"
+	ctx, cancelFunc := context.WithTimeout(ctx, 10*time.Second)
 	defer cancelFunc()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	grouping := deps.Setting("thorchain_grouping", "operator")
+	if grouping != "operator" && grouping != "bond_provider" && grouping != "none" {
+		return Report{}, fmt.Errorf("unknown thorchain grouping %q", grouping)
+	}
+
+	mapping, err := entities.Load(ctx, deps, deps.Setting("thorchain_entities", ""))
 	if err != nil {
-		log.Println(err)
-		return 0, errors.New("create get request for thorchain")
+		return Report{}, fmt.Errorf("failed to load entities for thorchain: %w", err)
 	}
 
-	resp, err := new(http.Client).Do(req)
+	nodes, err := fetchThorchainNodes(ctx, deps, url)
 	if err != nil {
-		log.Println(err)
-		return 0, errors.New("get request unsuccessful")
+		return Report{}, err
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	var active, standby []entities.Validator
+	for _, node := range nodes {
+		isActive, isStandby := node.Status == "Active", thorchainChurnEligible(node)
+		if !isActive && !isStandby {
+			// Disabled, whitelisted and standby nodes that cannot churn in hold no voting power.
+			continue
+		}
+
+		bond, ok := new(big.Int).SetString(node.Bond, 10)
+		if !ok {
+			return Report{}, fmt.Errorf("node %s: invalid total bond %q", node.NodeAddress, node.Bond)
+		}
+
+		v := entities.Validator{Address: node.NodeAddress, Stake: bond}
+		switch grouping {
+		case "operator":
+			v.Identity = node.NodeOperatorAddress
+		case "bond_provider":
+			if v.Identity, err = thorchainMainBondProvider(node); err != nil {
+				return Report{}, err
+			}
+		}
+
+		if isActive {
+			active = append(active, v)
+		} else {
+			standby = append(standby, v)
+		}
+	}
+	if len(active) == 0 {
+		return Report{}, fmt.Errorf("no active thorchain nodes found among %d nodes", len(nodes))
+	}
+
+	coefficients := entities.Calculate(active, mapping, nil)
+	deps.Logger.Printf("Thorchain: %d active nodes in %d entities, %d churn-eligible standby nodes",
+		len(active), coefficients.EntityCount, len(standby))
+	deps.Logger.Println("The Nakamoto coefficient for thorchain is", coefficients.Validators)
+
+	report := Report{Coefficient: coefficients.Validators}
+	if grouping != "none" {
+		report = entityReport(coefficients)
+	}
+
+	withStandby := entities.Calculate(append(active[:len(active):len(active)], standby...), mapping, nil)
+	report.Metrics = append(report.Metrics,
+		Metric{
+			Name:        "standby_nodes",
+			Value:       len(standby),
+			Methodology: "standby nodes passing preflight checks and eligible to churn in",
+		},
+		Metric{
+			Name:        "with_standby",
+			Value:       withStandby.Validators,
+			Methodology: "active and churn-eligible standby nodes counted independently, 33% threshold",
+		},
+	)
+
+	return report, nil
+}
+
+func fetchThorchainNodes(ctx context.Context, deps Deps, url string) (ThorchainResponse, error) {
+	resp, err := deps.Get(ctx, url)
 	if err != nil {
-		return 0, err
+		return nil, fmt.Errorf("get thorchain nodes: %w", err)
 	}
+	defer resp.Body.Close()
 
-	var response ThorchainResponse
-	err = json.Unmarshal(body, &response)
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	// loop through the validators voting powers
-	for _, ele := range response {
-		n, ok := new(big.Int).SetString(ele.Bond, 10)
-		if !ok {
-			log.Fatalln("Couldn't parse string", ele.Bond)
-		} else if ele.Status == "Active" {
-			// Assuming we calculate only for stakers with "active" stakers
-			// And discard "disabled" and "standby" stakers
-			votingPowers = append(votingPowers, *n)
+	if resp.StatusCode != http.StatusOK {
+		var errResp ThorchainErrorResponse
+		if json.Unmarshal(body, &errResp) == nil && errResp.Error != "" {
+			return nil, fmt.Errorf("thornode returned status %d: %s", resp.StatusCode, errResp.Error)
 		}
+		return nil, fmt.Errorf("thornode returned status %d", resp.StatusCode)
 	}
 
-	// need to sort the powers in descending order since they are in random order
-	sort.Slice(votingPowers, func(i, j int) bool {
-		res := (&votingPowers[i]).Cmp(&votingPowers[j])
-		if res == 1 {
-			return true
-		}
+	var response ThorchainResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// thorchainChurnEligible reports whether node is a standby node that could be churned in: it is
+// Ready, or Standby while passing the preflight checks, and is not leaving.
+func thorchainChurnEligible(node ThorchainNode) bool {
+	if node.RequestedToLeave || node.ForcedToLeave {
 		return false
-	})
+	}
 
-	totalVotingPower := utils.CalculateTotalVotingPowerBigNums(votingPowers)
-	fmt.Println("Total voting power:", totalVotingPower)
+	return node.Status == "Ready" || (node.Status == "Standby" && node.PreflightStatus.Status == "Ready")
+}
 
-	// now we're ready to calculate the nakomoto coefficient
-	nakamotoCoefficient := utils.CalcNakamotoCoefficientBigNums(totalVotingPower, votingPowers)
-	fmt.Println("The Nakamoto coefficient for thorchain is", nakamotoCoefficient)
+// thorchainMainBondProvider returns the bond provider contributing most of the bond of node, or the
+// node operator if it lists no providers.
+func thorchainMainBondProvider(node ThorchainNode) (string, error) {
+	var (
+		provider = node.NodeOperatorAddress
+		largest  = new(big.Int)
+	)
+	for _, p := range node.BondProviders.Providers {
+		bond, ok := new(big.Int).SetString(p.Bond, 10)
+		if !ok {
+			return "", fmt.Errorf("node %s: invalid bond %q of provider %s", node.NodeAddress, p.Bond, p.BondAddress)
+		}
+		if bond.Cmp(largest) > 0 {
+			provider, largest = p.BondAddress, bond
+		}
+	}
 
-	return nakamotoCoefficient, nil
+	return provider, nil
 }
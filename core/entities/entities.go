@@ -0,0 +1,186 @@
+// Package entities attributes validators to the entities operating them, so that Nakamoto
+// coefficients can be calculated over independent operators instead of individual keys.
+//
+// An entity is resolved from, in order of precedence, an explicit address mapping loaded from a
+// local file or remote list, an identity reported by the chain itself (a Cosmos Keybase identity,
+// a Polkadot parent identity, ...) and finally the validator address, which treats the validator
+// as an entity of its own.
+package entities
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	utils "github.com/xenowits/nakamoto-coefficient-calculator/core/utils"
+)
+
+// Getter issues GET requests, such as chains.Deps.
+type Getter interface {
+	Get(ctx context.Context, url string) (*http.Response, error)
+}
+
+// Mapping maps lowercased validator addresses to entity names.
+type Mapping map[string]string
+
+// Validator is the stake of a single validator.
+type Validator struct {
+	Address string
+	// Identity optionally identifies the operator as reported by the chain. Validators sharing a
+	// non-empty Identity belong to the same entity unless the mapping says otherwise.
+	Identity string
+	Stake    *big.Int
+}
+
+// Coefficients contains the Nakamoto coefficient over validators and over entities.
+type Coefficients struct {
+	Validators int
+	Entities   int
+	// EntityCount is the number of distinct entities.
+	EntityCount int
+}
+
+// Load reads a mapping from a local file or an http(s) URL. Two formats are accepted:
+//
+//	{"<address>": "<entity>", ...}
+//	{"entities": [{"entity": "<entity>", "addresses": ["<address>", ...]}, ...]}
+//
+// The second format also accepts "representatives" in place of "addresses", as published by
+// nanocharts. An empty source yields an empty mapping.
+func Load(ctx context.Context, getter Getter, source string) (Mapping, error) {
+	if source == "" {
+		return Mapping{}, nil
+	}
+
+	data, err := read(ctx, getter, source)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+
+	return m, nil
+}
+
+// Parse decodes a mapping in one of the formats accepted by Load.
+func Parse(data []byte) (Mapping, error) {
+	var list struct {
+		Entities []struct {
+			Entity          string   `json:"entity"`
+			Addresses       []string `json:"addresses"`
+			Representatives []string `json:"representatives"`
+		} `json:"entities"`
+	}
+	if err := json.Unmarshal(data, &list); err == nil && list.Entities != nil {
+		m := make(Mapping)
+		for _, e := range list.Entities {
+			for _, addr := range append(e.Addresses, e.Representatives...) {
+				m[strings.ToLower(addr)] = e.Entity
+			}
+		}
+
+		return m, nil
+	}
+
+	var flat map[string]string
+	if err := json.Unmarshal(data, &flat); err != nil {
+		return nil, err
+	}
+
+	m := make(Mapping, len(flat))
+	for addr, entity := range flat {
+		m[strings.ToLower(addr)] = entity
+	}
+
+	return m, nil
+}
+
+func read(ctx context.Context, getter Getter, source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return os.ReadFile(source)
+	}
+
+	resp, err := getter.Get(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status %d", source, resp.StatusCode)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// Entity returns the entity mapped to address, if any.
+func (m Mapping) Entity(address string) (string, bool) {
+	entity, ok := m[strings.ToLower(address)]
+	return entity, ok
+}
+
+// Resolve returns the entity operating v.
+func (m Mapping) Resolve(v Validator) string {
+	if entity, ok := m.Entity(v.Address); ok {
+		return entity
+	}
+	if v.Identity != "" {
+		return "identity:" + strings.ToLower(v.Identity)
+	}
+
+	return "address:" + strings.ToLower(v.Address)
+}
+
+// Powers returns the stake of each validator and the summed stake of each entity, both sorted in
+// descending order.
+func Powers(validators []Validator, m Mapping) (perValidator, perEntity []big.Int) {
+	stakePerEntity := make(map[string]*big.Int)
+	for _, v := range validators {
+		perValidator = append(perValidator, *v.Stake)
+
+		entity := m.Resolve(v)
+		if stakePerEntity[entity] == nil {
+			stakePerEntity[entity] = new(big.Int)
+		}
+		stakePerEntity[entity].Add(stakePerEntity[entity], v.Stake)
+	}
+
+	for _, stake := range stakePerEntity {
+		perEntity = append(perEntity, *stake)
+	}
+
+	sortDescending(perValidator)
+	sortDescending(perEntity)
+
+	return perValidator, perEntity
+}
+
+// Calculate returns the Nakamoto coefficients of validators at the 33% threshold. If total is nil,
+// the sum of the stake of validators is used.
+func Calculate(validators []Validator, m Mapping, total *big.Int) Coefficients {
+	perValidator, perEntity := Powers(validators, m)
+	if total == nil {
+		total = utils.CalculateTotalVotingPowerBigNums(perValidator)
+	}
+
+	return Coefficients{
+		Validators:  utils.CalcNakamotoCoefficientBigNums(total, perValidator),
+		Entities:    utils.CalcNakamotoCoefficientBigNums(total, perEntity),
+		EntityCount: len(perEntity),
+	}
+}
+
+func sortDescending(powers []big.Int) {
+	sort.Slice(powers, func(i, j int) bool {
+		return powers[i].Cmp(&powers[j]) > 0
+	})
+}
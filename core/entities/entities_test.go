@@ -0,0 +1,154 @@
+package entities
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want Mapping
+	}{
+		{"flat", `{"0xAB": "Foo", "0xcd": "Bar"}`, Mapping{"0xab": "Foo", "0xcd": "Bar"}},
+		{"addresses", `{"entities": [{"entity": "Foo", "addresses": ["0xAB", "0xcd"]}]}`, Mapping{"0xab": "Foo", "0xcd": "Foo"}},
+		{"representatives", `{"entities": [{"entity": "Foo", "representatives": ["nano_1"]}]}`, Mapping{"nano_1": "Foo"}},
+		{"duplicate address", `{"entities": [{"entity": "Foo", "addresses": ["0xab"]}, {"entity": "Bar", "addresses": ["0xAB"]}]}`, Mapping{"0xab": "Bar"}},
+		{"empty object", `{}`, Mapping{}},
+		{"empty list", `{"entities": []}`, Mapping{}},
+	}
+
+	for _, tt := range tests {
+		got, err := Parse([]byte(tt.data))
+		if err != nil {
+			t.Errorf("%s: Parse: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Parse = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, data := range []string{"", "[]", `{"0xab": 1}`, `{"entities": [`} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", data)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	m := Mapping{"0xab": "Foo"}
+
+	tests := []struct {
+		name string
+		v    Validator
+		want string
+	}{
+		{"mapped", Validator{Address: "0xAB"}, "Foo"},
+		{"mapping before identity", Validator{Address: "0xab", Identity: "Bar"}, "Foo"},
+		{"identity fallback", Validator{Address: "0xcd", Identity: "Bar"}, "identity:bar"},
+		{"address fallback", Validator{Address: "0xCD"}, "address:0xcd"},
+		{"missing mapping entry", Validator{Address: "0xef"}, "address:0xef"},
+	}
+
+	for _, tt := range tests {
+		if got := m.Resolve(tt.v); got != tt.want {
+			t.Errorf("%s: Resolve = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPowers(t *testing.T) {
+	tests := []struct {
+		name         string
+		validators   []Validator
+		m            Mapping
+		perValidator []int64
+		perEntity    []int64
+	}{
+		{"empty", nil, Mapping{}, nil, nil},
+		{
+			"mapped",
+			validators(10, 30, 20),
+			Mapping{"v0": "Foo", "v2": "Foo"},
+			[]int64{30, 20, 10},
+			[]int64{30, 30},
+		},
+		{
+			"identity",
+			[]Validator{
+				{Address: "v0", Identity: "Foo", Stake: big.NewInt(10)},
+				{Address: "v1", Identity: "foo", Stake: big.NewInt(5)},
+				{Address: "v2", Stake: big.NewInt(20)},
+			},
+			Mapping{},
+			[]int64{20, 10, 5},
+			[]int64{20, 15},
+		},
+		{
+			"duplicate addresses",
+			[]Validator{
+				{Address: "v0", Stake: big.NewInt(10)},
+				{Address: "V0", Stake: big.NewInt(5)},
+			},
+			Mapping{},
+			[]int64{10, 5},
+			[]int64{15},
+		},
+	}
+
+	for _, tt := range tests {
+		perValidator, perEntity := Powers(tt.validators, tt.m)
+		if got := int64s(perValidator); !reflect.DeepEqual(got, tt.perValidator) {
+			t.Errorf("%s: validator powers = %v, want %v", tt.name, got, tt.perValidator)
+		}
+		if got := int64s(perEntity); !reflect.DeepEqual(got, tt.perEntity) {
+			t.Errorf("%s: entity powers = %v, want %v", tt.name, got, tt.perEntity)
+		}
+	}
+}
+
+func TestCalculate(t *testing.T) {
+	tests := []struct {
+		name       string
+		validators []Validator
+		m          Mapping
+		total      *big.Int
+		want       Coefficients
+	}{
+		{"empty", nil, Mapping{}, nil, Coefficients{}},
+		{"unmapped", validators(10, 10, 10, 10), Mapping{}, nil, Coefficients{Validators: 2, Entities: 2, EntityCount: 4}},
+		{"mapped", validators(10, 10, 10, 10), Mapping{"v0": "Foo", "v1": "Foo"}, nil, Coefficients{Validators: 2, Entities: 1, EntityCount: 3}},
+		{"missing mapping entry", validators(10, 10, 10, 10), Mapping{"v9": "Foo"}, nil, Coefficients{Validators: 2, Entities: 2, EntityCount: 4}},
+		{"reported total", validators(10, 10, 10, 10), Mapping{"v0": "Foo", "v1": "Foo"}, big.NewInt(100), Coefficients{Validators: 4, Entities: 3, EntityCount: 3}},
+	}
+
+	for _, tt := range tests {
+		if got := Calculate(tt.validators, tt.m, tt.total); got != tt.want {
+			t.Errorf("%s: Calculate = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// validators returns validators v0, v1, ... with the given stakes.
+func validators(stakes ...int64) []Validator {
+	var vs []Validator
+	for i, stake := range stakes {
+		vs = append(vs, Validator{Address: "v" + string(rune('0'+i)), Stake: big.NewInt(stake)})
+	}
+
+	return vs
+}
+
+func int64s(powers []big.Int) []int64 {
+	var out []int64
+	for _, p := range powers {
+		out = append(out, p.Int64())
+	}
+
+	return out
+}
@@ -0,0 +1,228 @@
+// Package jsonrpc is a JSON-RPC 2.0 client over HTTP supporting single and batched calls.
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTimeout bounds a single HTTP request of a Client unless Timeout is set.
+const DefaultTimeout = 30 * time.Second
+
+// ErrHTML is returned when an endpoint answers with an HTML page, typically an error page of a
+// proxy or a rate limiter, instead of JSON.
+var ErrHTML = errors.New("jsonrpc: HTML response")
+
+// Error is a JSON-RPC error object returned by the server.
+type Error struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("jsonrpc: error %d: %s", e.Code, e.Message)
+}
+
+// StatusError is returned when the HTTP status of a response is not 200 OK.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("jsonrpc: HTTP status %d", e.StatusCode)
+}
+
+// Request is a single call of a batch.
+type Request struct {
+	Method string
+	// Params is marshalled as is, so it may be positional ([]interface{}) or named (a map or struct).
+	// A nil Params is sent as an empty array.
+	Params interface{}
+}
+
+// Response is the result of a single call of a batch. Exactly one of Result and Error is set.
+type Response struct {
+	Result json.RawMessage
+	Error  *Error
+}
+
+// Decode unmarshals the result of r into v, or returns the error object of r.
+func (r Response) Decode(v interface{}) error {
+	if r.Error != nil {
+		return r.Error
+	}
+
+	return json.Unmarshal(r.Result, v)
+}
+
+// Client calls a single JSON-RPC endpoint.
+type Client struct {
+	HTTPClient *http.Client
+	URL        string
+	// Timeout bounds each HTTP request. Zero means DefaultTimeout.
+	Timeout time.Duration
+	// Header is added to every request.
+	Header http.Header
+
+	nextID uint64
+}
+
+// New returns a client for the endpoint at url.
+func New(httpClient *http.Client, url string) *Client {
+	return &Client{HTTPClient: httpClient, URL: url}
+}
+
+type request struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      uint64      `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type response struct {
+	ID     json.RawMessage `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *Error          `json:"error"`
+}
+
+// Call calls method with params and unmarshals the result into result. A JSON-RPC error object
+// is returned as an *Error.
+func (c *Client) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	req := c.newRequest(Request{Method: method, Params: params})
+
+	var resp response
+	if err := c.post(ctx, req, &resp); err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	if id, err := parseID(resp.ID); resp.Error == nil && (err != nil || id != req.ID) {
+		return fmt.Errorf("%s: jsonrpc: response id %s does not match request id %d", method, resp.ID, req.ID)
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("%s: %w", method, resp.Error)
+	}
+
+	if err := json.Unmarshal(resp.Result, result); err != nil {
+		return fmt.Errorf("%s: decode result: %w", method, err)
+	}
+
+	return nil
+}
+
+// Batch sends requests as a single batch and returns their responses in the same order, matched
+// by id. Errors of individual calls are reported in the corresponding Response.
+func (c *Client) Batch(ctx context.Context, requests []Request) ([]Response, error) {
+	if len(requests) == 0 {
+		return nil, nil
+	}
+
+	reqs := make([]request, 0, len(requests))
+	index := make(map[uint64]int, len(requests))
+	for i, r := range requests {
+		req := c.newRequest(r)
+		reqs = append(reqs, req)
+		index[req.ID] = i
+	}
+
+	var resps []response
+	if err := c.post(ctx, reqs, &resps); err != nil {
+		return nil, fmt.Errorf("batch of %d: %w", len(requests), err)
+	}
+
+	out := make([]Response, len(requests))
+	seen := make([]bool, len(requests))
+	for _, resp := range resps {
+		id, err := parseID(resp.ID)
+		i, ok := index[id]
+		if err != nil || !ok || seen[i] {
+			return nil, fmt.Errorf("batch of %d: jsonrpc: unexpected response id %s", len(requests), resp.ID)
+		}
+
+		out[i] = Response{Result: resp.Result, Error: resp.Error}
+		seen[i] = true
+	}
+	for i, ok := range seen {
+		if !ok {
+			return nil, fmt.Errorf("batch of %d: jsonrpc: no response for %s", len(requests), requests[i].Method)
+		}
+	}
+
+	return out, nil
+}
+
+func (c *Client) newRequest(r Request) request {
+	params := r.Params
+	if params == nil {
+		params = []interface{}{}
+	}
+
+	return request{
+		JSONRPC: "2.0",
+		ID:      atomic.AddUint64(&c.nextID, 1),
+		Method:  r.Method,
+		Params:  params,
+	}
+}
+
+// post sends payload and decodes the JSON response into v.
+func (c *Client) post(ctx context.Context, payload interface{}, v interface{}) error {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range c.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '<' {
+		return fmt.Errorf("%w with HTTP status %d", ErrHTML, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{StatusCode: resp.StatusCode}
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("jsonrpc: decode response: %w", err)
+	}
+
+	return nil
+}
+
+// parseID parses a response id, which this client always sends as a number.
+func parseID(raw json.RawMessage) (uint64, error) {
+	var id uint64
+	err := json.Unmarshal(raw, &id)
+
+	return id, err
+}
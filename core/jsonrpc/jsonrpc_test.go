@@ -0,0 +1,181 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// echo answers every request with its method as result, or with an error object for methods
+// named "fail". Batches are answered in reverse order.
+func echo(t *testing.T, w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	answer := func(req request) map[string]interface{} {
+		if req.Method == "fail" {
+			return map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "error": Error{Code: -32000, Message: "failed"}}
+		}
+		return map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": req.Method}
+	}
+
+	var batch []request
+	if err := json.Unmarshal(body, &batch); err == nil {
+		out := make([]map[string]interface{}, 0, len(batch))
+		for i := len(batch) - 1; i >= 0; i-- {
+			out = append(out, answer(batch[i]))
+		}
+		_ = json.NewEncoder(w).Encode(out)
+		return
+	}
+
+	var req request
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Error(err)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(answer(req))
+}
+
+func TestCall(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("User-Agent"); got != "test/1.0" {
+			t.Errorf("User-Agent = %q", got)
+		}
+		echo(t, w, r)
+	}))
+	defer srv.Close()
+
+	c := New(srv.Client(), srv.URL)
+	c.Header = http.Header{"User-Agent": {"test/1.0"}}
+
+	var result string
+	if err := c.Call(context.Background(), "eth_blockNumber", nil, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result != "eth_blockNumber" {
+		t.Errorf("result = %q", result)
+	}
+
+	err := c.Call(context.Background(), "fail", nil, &result)
+	var rpcErr *Error
+	if !errors.As(err, &rpcErr) || rpcErr.Code != -32000 {
+		t.Errorf("Call(fail) error = %v, want *Error with code -32000", err)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { echo(t, w, r) }))
+	defer srv.Close()
+
+	c := New(srv.Client(), srv.URL)
+	resps, err := c.Batch(context.Background(), []Request{{Method: "a"}, {Method: "fail"}, {Method: "c", Params: []interface{}{1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"a", "", "c"}
+	for i, resp := range resps {
+		var result string
+		err := resp.Decode(&result)
+		if want[i] == "" {
+			if err == nil {
+				t.Errorf("response %d: want error", i)
+			}
+			continue
+		}
+		if err != nil || result != want[i] {
+			t.Errorf("response %d = %q, %v, want %q", i, result, err, want[i])
+		}
+	}
+
+	if resps, err := c.Batch(context.Background(), nil); err != nil || resps != nil {
+		t.Errorf("empty batch = %v, %v", resps, err)
+	}
+}
+
+func TestBatchMismatchedIDs(t *testing.T) {
+	tests := []struct {
+		name string
+		// reply returns the batch response given the ids of the requests.
+		reply func(ids []uint64) string
+	}{
+		{"missing response", func(ids []uint64) string {
+			return fmt.Sprintf(`[{"jsonrpc":"2.0","id":%d,"result":1}]`, ids[0])
+		}},
+		{"unknown id", func(ids []uint64) string {
+			return fmt.Sprintf(`[{"jsonrpc":"2.0","id":%d,"result":1},{"jsonrpc":"2.0","id":999,"result":1}]`, ids[0])
+		}},
+		{"duplicate id", func(ids []uint64) string {
+			return fmt.Sprintf(`[{"jsonrpc":"2.0","id":%d,"result":1},{"jsonrpc":"2.0","id":%d,"result":1}]`, ids[0], ids[0])
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var batch []request
+				if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+					t.Error(err)
+					return
+				}
+				ids := make([]uint64, 0, len(batch))
+				for _, req := range batch {
+					ids = append(ids, req.ID)
+				}
+				_, _ = io.WriteString(w, tt.reply(ids))
+			}))
+			defer srv.Close()
+
+			_, err := New(srv.Client(), srv.URL).Batch(context.Background(), []Request{{Method: "a"}, {Method: "b"}})
+			if err == nil {
+				t.Error("Batch succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestHTTPErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		check  func(error) bool
+	}{
+		{"html page", http.StatusTooManyRequests, "\n<html>rate limited</html>", func(err error) bool {
+			return errors.Is(err, ErrHTML)
+		}},
+		{"status", http.StatusBadGateway, `{}`, func(err error) bool {
+			var statusErr *StatusError
+			return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusBadGateway
+		}},
+		{"invalid json", http.StatusOK, `{"jsonrpc":`, func(err error) bool {
+			return err != nil && strings.Contains(err.Error(), "decode response")
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = io.WriteString(w, tt.body)
+			}))
+			defer srv.Close()
+
+			var result string
+			err := New(srv.Client(), srv.URL).Call(context.Background(), "eth_chainId", nil, &result)
+			if !tt.check(err) {
+				t.Errorf("Call error = %v", err)
+			}
+		})
+	}
+}
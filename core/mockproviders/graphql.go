@@ -0,0 +1,66 @@
+package mockproviders
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+)
+
+// graphDelegationRatio is the delegation ratio of the mock Graph Network.
+const graphDelegationRatio = 16
+
+type graphQLRequest struct {
+	Query     string `json:"query"`
+	Variables struct {
+		First  int    `json:"first"`
+		LastID string `json:"lastID"`
+	} `json:"variables"`
+}
+
+// serveGraphQL answers POST <base>/.../subgraphs/id/<id> of the Graph Network subgraph, for the
+// graphNetwork and indexers queries. Indexers are ordered by id and paged with id_gt; every third
+// one has more delegation than the delegation ratio allows.
+func serveGraphQL(w http.ResponseWriter, r *http.Request, validators []Validator) {
+	var req graphQLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"errors": []map[string]string{{"message": err.Error()}}})
+		return
+	}
+
+	switch {
+	case strings.Contains(req.Query, "graphNetwork"):
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"data": map[string]interface{}{"graphNetwork": map[string]interface{}{"delegationRatio": graphDelegationRatio}},
+		})
+	case strings.Contains(req.Query, "indexers"):
+		first := req.Variables.First
+		if first <= 0 {
+			first = 100
+		}
+
+		indexers := make([]map[string]string, 0, first)
+		for i, v := range validators {
+			// Ids are zero padded, so they sort in the order of the validators.
+			id := fmt.Sprintf("0x%040x", i+1)
+			if id <= req.Variables.LastID || len(indexers) == first {
+				continue
+			}
+
+			delegated := new(big.Int).Mul(v.Stake, big.NewInt(5))
+			if i%3 == 0 {
+				delegated.Mul(v.Stake, big.NewInt(40))
+			}
+			indexers = append(indexers, map[string]string{
+				"id":              id,
+				"stakedTokens":    v.Stake.String(),
+				"delegatedTokens": delegated.String(),
+			})
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"indexers": indexers}})
+	default:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"errors": []map[string]string{{"message": "unsupported query"}}})
+	}
+}
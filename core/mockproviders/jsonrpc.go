@@ -0,0 +1,529 @@
+package mockproviders
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	monadSelectorGetValSet  = "fb29b729"
+	monadSelectorGetValInfo = "2b6d639a"
+	monadValSetPageSize     = 100
+
+	bscSelectorGetValidators       = "b7ab4db5"
+	bscSelectorGetConsensusAddress = "059ddd22"
+	// Operator addresses of BSC validators are offset from their consensus addresses by this value.
+	bscOperatorBase = 0x1000000
+	mockBlockNumber = 1_000_000
+	mockEpoch       = 500
+
+	// Rollup contracts. Every address is answered the same way, so the OP Stack SystemConfig,
+	// OptimismPortal, dispute games and ProxyAdmin as well as the ArbOS precompiles resolve to
+	// the addresses below, and rollup roles end at a 2 of 3 Safe or at externally owned accounts.
+	rollupSelectorBatcherHash        = "e81b2c6d"
+	rollupSelectorBatchInbox         = "dac6e63a"
+	rollupSelectorOptimismPortal     = "0a49cb03"
+	rollupSelectorDisputeGameFactory = "f2b4e617"
+	rollupSelectorRespectedGameType  = "3c9f397c"
+	rollupSelectorGameImpls          = "1b685b9e"
+	rollupSelectorChallenger         = "534db0e2"
+	rollupSelectorProposer           = "a8e4fb90"
+	rollupSelectorOwner              = "8da5cb5b"
+	rollupSelectorGetBatchPosters    = "e10573a3"
+	rollupSelectorGetAllChainOwners  = "516b4e0f"
+	safeSelectorGetThreshold         = "e75235b8"
+	safeSelectorGetOwners            = "a0e67e2b"
+	mockRollupContract               = 0xc0de
+	mockSafe                         = 0x5afe
+	mockBatcher                      = 0xba7c
+	mockBatchInbox                   = 0xb0c5
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+// positional returns the params of a request sent as an array, or nil for named params.
+func (r rpcRequest) positional() []json.RawMessage {
+	var params []json.RawMessage
+	if json.Unmarshal(r.Params, &params) != nil {
+		return nil
+	}
+
+	return params
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// serveJSONRPC answers JSON-RPC 2.0 requests, single or batched, for every RPC family
+// (EVM, Near, Sui) by dispatching on the method name.
+func serveJSONRPC(w http.ResponseWriter, r *http.Request, validators []Validator) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, rpcResponse{JSONRPC: "2.0", Error: &rpcError{Code: -32700, Message: err.Error()}})
+		return
+	}
+
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		var batch []rpcRequest
+		if err := json.Unmarshal(trimmed, &batch); err != nil {
+			writeJSON(w, http.StatusOK, rpcResponse{JSONRPC: "2.0", Error: &rpcError{Code: -32700, Message: "parse error"}})
+			return
+		}
+
+		responses := make([]rpcResponse, 0, len(batch))
+		for _, req := range batch {
+			responses = append(responses, handleRPC(req, validators))
+		}
+		writeJSON(w, http.StatusOK, responses)
+
+		return
+	}
+
+	var req rpcRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusOK, rpcResponse{JSONRPC: "2.0", Error: &rpcError{Code: -32700, Message: "parse error"}})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, handleRPC(req, validators))
+}
+
+func handleRPC(req rpcRequest, validators []Validator) rpcResponse {
+	resp := rpcResponse{JSONRPC: "2.0", ID: req.ID}
+
+	var err *rpcError
+	switch req.Method {
+	case "eth_blockNumber":
+		resp.Result = fmt.Sprintf("0x%x", mockBlockNumber)
+	case "eth_chainId":
+		resp.Result = "0x1"
+	case "eth_call":
+		resp.Result, err = ethCall(req, validators)
+	case "eth_getBlockByNumber":
+		resp.Result, err = ethBlock(req, validators)
+	case "eth_getCode":
+		resp.Result = ethCode(req)
+	case "eth_getStorageAt":
+		// The admin slot of every proxy points at the mock ProxyAdmin.
+		resp.Result = encodeWords([]*big.Int{big.NewInt(mockRollupContract)})
+	case "validators":
+		// CometBFT takes named params, Near takes a positional block reference.
+		var named struct {
+			Page    string `json:"page"`
+			PerPage string `json:"per_page"`
+		}
+		if json.Unmarshal(req.Params, &named) == nil {
+			page, _ := strconv.Atoi(named.Page)
+			perPage, _ := strconv.Atoi(named.PerPage)
+			resp.Result = cometBFTValidators(page, perPage, validators)
+		} else {
+			resp.Result = nearValidators(validators)
+		}
+	case "EXPERIMENTAL_protocol_config":
+		resp.Result = map[string]interface{}{"num_block_producer_seats": 300, "minimum_stake_ratio": []int{1, 6250}}
+	case "query":
+		resp.Result, err = nearQuery(req, validators)
+	case "chain_getFinalizedHead", "state_getStorage", "state_getKeysPaged", "state_queryStorageAt":
+		resp.Result, err = substrateRPC(req, validators)
+	case "getEpochInfo":
+		resp.Result = map[string]interface{}{"epoch": mockEpoch, "absoluteSlot": mockBlockNumber}
+	case "getVoteAccounts":
+		resp.Result = solanaVoteAccounts(validators)
+	case "platform.getCurrentValidators":
+		resp.Result = avalancheValidators(req, validators)
+	case "suix_getLatestSuiSystemState":
+		resp.Result = suiSystemState(validators)
+	default:
+		err = &rpcError{Code: -32601, Message: "the method " + req.Method + " does not exist/is not available"}
+	}
+
+	if err != nil {
+		resp.Result = nil
+		resp.Error = err
+	}
+
+	return resp
+}
+
+// ethBlock returns a block whose producer rotates through the validators by block number. With
+// full transactions, even blocks carry a batch of the mock batcher and odd blocks one of the second
+// batch poster, alongside a batch of an unknown sender and a transfer of the batcher elsewhere.
+func ethBlock(req rpcRequest, validators []Validator) (interface{}, *rpcError) {
+	var (
+		number string
+		full   bool
+	)
+	params := req.positional()
+	if len(params) == 0 || json.Unmarshal(params[0], &number) != nil {
+		return nil, &rpcError{Code: -32602, Message: "invalid block number"}
+	}
+	if len(params) > 1 {
+		_ = json.Unmarshal(params[1], &full)
+	}
+
+	n, ok := new(big.Int).SetString(strings.TrimPrefix(number, "0x"), 16)
+	if !ok || len(validators) == 0 {
+		return nil, nil
+	}
+
+	producer := new(big.Int).Mod(n, big.NewInt(int64(len(validators)))).Int64()
+	block := map[string]interface{}{
+		"number": number,
+		"miner":  fmt.Sprintf("0x%040x", producer+1),
+	}
+	if full {
+		address := func(a int64) string { return fmt.Sprintf("0x%040x", a) }
+		batcher := int64(mockBatcher) + int64(n.Bit(0))
+		block["transactions"] = []map[string]string{
+			{"from": address(batcher), "to": address(mockBatchInbox)},
+			{"from": address(0x0bad), "to": address(mockBatchInbox)},
+			{"from": address(mockBatcher), "to": address(0x1234)},
+		}
+	}
+
+	return block, nil
+}
+
+// ethCall impersonates the Monad staking precompile and the BSC validator set and StakeHub contracts.
+func ethCall(req rpcRequest, validators []Validator) (interface{}, *rpcError) {
+	params := req.positional()
+	if len(params) == 0 {
+		return nil, &rpcError{Code: -32602, Message: "missing call object"}
+	}
+
+	var call struct {
+		To   string `json:"to"`
+		Data string `json:"data"`
+	}
+	if err := json.Unmarshal(params[0], &call); err != nil {
+		return nil, &rpcError{Code: -32602, Message: "invalid call object"}
+	}
+
+	data := strings.TrimPrefix(call.Data, "0x")
+	if len(data) < 8 {
+		return nil, &rpcError{Code: 3, Message: "execution reverted"}
+	}
+
+	// BSC getValidators takes no argument.
+	if data[:8] == bscSelectorGetValidators {
+		return bscActiveSet(len(validators)), nil
+	}
+	if result, ok := rollupCall(data[:8]); ok {
+		return result, nil
+	}
+
+	if len(data) < 8+64 {
+		return nil, &rpcError{Code: 3, Message: "execution reverted"}
+	}
+
+	arg, ok := new(big.Int).SetString(data[8:8+64], 16)
+	if !ok {
+		return nil, &rpcError{Code: -32602, Message: "invalid call data"}
+	}
+
+	switch data[:8] {
+	case monadSelectorGetValSet:
+		return monadValidatorSet(int(arg.Int64()), len(validators)), nil
+	case monadSelectorGetValInfo:
+		id := int(arg.Int64())
+		if id < 1 || id > len(validators) {
+			return nil, &rpcError{Code: 3, Message: "execution reverted: unknown validator"}
+		}
+
+		return monadValidator(validators[id-1]), nil
+	case rollupSelectorGameImpls:
+		return encodeWords([]*big.Int{big.NewInt(mockRollupContract)}), nil
+	case bscSelectorGetConsensusAddress:
+		id := new(big.Int).Sub(arg, big.NewInt(bscOperatorBase)).Int64()
+		if id < 1 || id > int64(len(validators)) {
+			return nil, &rpcError{Code: 3, Message: "execution reverted: unknown operator"}
+		}
+
+		return encodeWords([]*big.Int{big.NewInt(id)}), nil
+	default:
+		return nil, &rpcError{Code: 3, Message: "execution reverted: unknown selector"}
+	}
+}
+
+// ethCode returns code for the mock Safe and reports every other address as an externally owned account.
+func ethCode(req rpcRequest) string {
+	var addr string
+	params := req.positional()
+	if len(params) > 0 && json.Unmarshal(params[0], &addr) == nil {
+		if n, ok := new(big.Int).SetString(strings.TrimPrefix(addr, "0x"), 16); ok && n.Int64() == mockSafe {
+			return "0x6080"
+		}
+	}
+
+	return "0x"
+}
+
+// rollupCall answers the calls without arguments made to rollup contracts and Safes.
+func rollupCall(selector string) (string, bool) {
+	word := func(n int64) string { return encodeWords([]*big.Int{big.NewInt(n)}) }
+	addresses := func(addrs ...int64) string {
+		words := []*big.Int{big.NewInt(32), big.NewInt(int64(len(addrs)))}
+		for _, a := range addrs {
+			words = append(words, big.NewInt(a))
+		}
+		return encodeWords(words)
+	}
+
+	switch selector {
+	case rollupSelectorBatcherHash, rollupSelectorProposer:
+		return word(mockBatcher), true
+	case rollupSelectorBatchInbox:
+		return word(mockBatchInbox), true
+	case rollupSelectorOptimismPortal, rollupSelectorDisputeGameFactory:
+		return word(mockRollupContract), true
+	case rollupSelectorRespectedGameType:
+		return word(1), true
+	case rollupSelectorChallenger, rollupSelectorOwner:
+		return word(mockSafe), true
+	case rollupSelectorGetBatchPosters:
+		return addresses(mockBatcher, mockBatcher+1), true
+	case rollupSelectorGetAllChainOwners:
+		return addresses(mockSafe), true
+	case safeSelectorGetThreshold:
+		return word(2), true
+	case safeSelectorGetOwners:
+		return addresses(0x0e01, 0x0e02, 0x0e03), true
+	default:
+		return "", false
+	}
+}
+
+// bscActiveSet encodes the address[] returned by getValidators of the BSC validator set
+// contract: the consensus addresses of every validator except the last one.
+func bscActiveSet(count int) string {
+	if count > 0 {
+		count--
+	}
+
+	words := []*big.Int{big.NewInt(32), big.NewInt(int64(count))}
+	for id := 1; id <= count; id++ {
+		words = append(words, big.NewInt(int64(id)))
+	}
+
+	return encodeWords(words)
+}
+
+// monadValidatorSet encodes (bool isDone, uint32 nextIndex, uint64[] valIds) for validator IDs 1..count.
+func monadValidatorSet(start, count int) string {
+	end := start + monadValSetPageSize
+	if end >= count {
+		end = count
+	}
+	if start > end {
+		start = end
+	}
+
+	isDone := 0
+	if end == count {
+		isDone = 1
+	}
+
+	words := []*big.Int{
+		big.NewInt(int64(isDone)),
+		big.NewInt(int64(end)),
+		big.NewInt(3 * 32),
+		big.NewInt(int64(end - start)),
+	}
+	for id := start + 1; id <= end; id++ {
+		words = append(words, big.NewInt(int64(id)))
+	}
+
+	return encodeWords(words)
+}
+
+// monadValidator encodes the getValidator tuple: ten static words followed by two empty
+// dynamic byte strings (secp and bls public keys).
+func monadValidator(v Validator) string {
+	zero := big.NewInt(0)
+	words := []*big.Int{
+		zero,                // authAddress
+		zero,                // flags
+		v.Stake,             // stake
+		zero,                // accRewardPerToken
+		zero,                // commission
+		zero,                // unclaimedRewards
+		v.Stake,             // consensusStake
+		zero,                // consensusCommission
+		v.Stake,             // snapshotStake
+		zero,                // snapshotCommission
+		big.NewInt(12 * 32), // secpPubkey offset
+		big.NewInt(13 * 32), // blsPubkey offset
+		zero,                // secpPubkey length
+		zero,                // blsPubkey length
+	}
+
+	return encodeWords(words)
+}
+
+func encodeWords(words []*big.Int) string {
+	var sb strings.Builder
+	sb.WriteString("0x")
+	for _, w := range words {
+		fmt.Fprintf(&sb, "%064x", w)
+	}
+
+	return sb.String()
+}
+
+// solanaVoteAccounts reports every validator as a current vote account except the last one,
+// which is delinquent.
+func solanaVoteAccounts(validators []Validator) interface{} {
+	current := make([]map[string]interface{}, 0, len(validators))
+	delinquent := make([]map[string]interface{}, 0, 1)
+	for i, v := range validators {
+		acc := map[string]interface{}{
+			"votePubkey":       v.Address,
+			"nodePubkey":       v.Address,
+			"activatedStake":   v.Stake.Uint64(),
+			"epochVoteAccount": true,
+		}
+		if i == len(validators)-1 {
+			delinquent = append(delinquent, acc)
+		} else {
+			current = append(current, acc)
+		}
+	}
+
+	return map[string]interface{}{"current": current, "delinquent": delinquent}
+}
+
+// avalancheValidators answers the Avalanche platform.getCurrentValidators method. On the primary
+// network half of the stake of each validator is delegated; subnets only report a weight.
+func avalancheValidators(req rpcRequest, validators []Validator) interface{} {
+	var params struct {
+		SubnetID string `json:"subnetID"`
+	}
+	_ = json.Unmarshal(req.Params, &params)
+
+	list := make([]map[string]string, 0, len(validators))
+	for _, v := range validators {
+		validator := map[string]string{"nodeID": "NodeID-" + v.Address}
+		if params.SubnetID == "" {
+			delegated := new(big.Int).Div(v.Stake, big.NewInt(2))
+			validator["weight"] = new(big.Int).Sub(v.Stake, delegated).String()
+			validator["delegatorWeight"] = delegated.String()
+		} else {
+			validator["weight"] = v.Stake.String()
+		}
+		list = append(list, validator)
+	}
+
+	return map[string]interface{}{"validators": list}
+}
+
+// nearValidators answers the Near "validators" RPC method. The next epoch drops the last
+// validator, and the current proposals withdraw the second to last one.
+func nearValidators(validators []Validator) interface{} {
+	type validator struct {
+		AccountID string `json:"account_id"`
+		Stake     string `json:"stake"`
+	}
+
+	current := make([]validator, 0, len(validators))
+	for _, v := range validators {
+		current = append(current, validator{AccountID: nearAccount(v), Stake: v.Stake.String()})
+	}
+
+	next, proposals := current, []validator{}
+	if len(current) > 1 {
+		next = current[:len(current)-1]
+	}
+	if len(next) > 1 {
+		proposals = append(proposals, validator{AccountID: next[len(next)-1].AccountID, Stake: "0"})
+	}
+
+	return map[string]interface{}{
+		"current_validators": current,
+		"next_validators":    next,
+		"current_proposals":  proposals,
+		"epoch_start_height": mockBlockNumber,
+	}
+}
+
+func nearAccount(v Validator) string {
+	return v.Name + ".poolv1.near"
+}
+
+// nearQuery answers the Near "query" RPC method for calls to get_owner_id of staking pools.
+// Validators sharing an Identity share an owner.
+func nearQuery(req rpcRequest, validators []Validator) (interface{}, *rpcError) {
+	var params struct {
+		AccountID  string `json:"account_id"`
+		MethodName string `json:"method_name"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil || params.MethodName != "get_owner_id" {
+		return nil, &rpcError{Code: -32000, Message: "Server error"}
+	}
+
+	for _, v := range validators {
+		if nearAccount(v) != params.AccountID {
+			continue
+		}
+
+		owner := v.Name + ".near"
+		if v.Identity != "" {
+			owner = v.Identity + ".near"
+		}
+		raw, _ := json.Marshal(owner)
+		result := make([]int, len(raw))
+		for i, b := range raw {
+			result[i] = int(b)
+		}
+
+		return map[string]interface{}{"result": result, "logs": []string{}, "block_height": mockBlockNumber}, nil
+	}
+
+	return nil, &rpcError{Code: -32000, Message: "Server error: account " + params.AccountID + " does not exist"}
+}
+
+// suiSystemState answers the Sui suix_getLatestSuiSystemState RPC method.
+func suiSystemState(validators []Validator) interface{} {
+	type validator struct {
+		SuiAddress            string `json:"suiAddress"`
+		Name                  string `json:"name"`
+		VotingPower           string `json:"votingPower"`
+		StakingPoolSuiBalance string `json:"stakingPoolSuiBalance"`
+	}
+
+	total := totalStake(validators)
+	list := make([]validator, 0, len(validators))
+	for _, v := range validators {
+		list = append(list, validator{
+			SuiAddress:            v.Address,
+			Name:                  v.Name,
+			VotingPower:           fmt.Sprint(basisPoints(v.Stake, total)),
+			StakingPoolSuiBalance: v.Stake.String(),
+		})
+	}
+
+	return map[string]interface{}{
+		"epoch":            strconv.Itoa(mockEpoch),
+		"totalStake":       total.String(),
+		"activeValidators": list,
+	}
+}
@@ -0,0 +1,290 @@
+// Package mockproviders impersonates the upstream APIs consumed by core/chains so that the
+// calculator can be exercised end to end without touching the network.
+//
+// A single Server answers every supported API family at once; requests are routed by method
+// and path suffix, so a base URL such as http://127.0.0.1:8181/cosmoshub works the same way as
+// the real provider it replaces. Failure scenarios can be selected up front, switched at runtime
+// through the /_mock/scenario endpoint, or forced for a single request with the
+// X-Mock-Scenario header.
+package mockproviders
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Scenario selects how the mock server answers requests.
+type Scenario string
+
+const (
+	// Healthy answers every request with a well-formed payload.
+	Healthy Scenario = "healthy"
+	// Slow answers with a well-formed payload after Options.Delay.
+	Slow Scenario = "slow"
+	// ServerError answers with HTTP 500 and a JSON error body.
+	ServerError Scenario = "500"
+	// RateLimited answers with HTTP 429 and a Retry-After header.
+	RateLimited Scenario = "429"
+	// TruncatedJSON answers with HTTP 200 and the first half of a well-formed payload.
+	TruncatedJSON Scenario = "truncated"
+	// HTMLError answers with HTTP 502 and an HTML error page, as reverse proxies commonly do.
+	HTMLError Scenario = "html"
+)
+
+// Scenarios lists every supported scenario.
+var Scenarios = []Scenario{Healthy, Slow, ServerError, RateLimited, TruncatedJSON, HTMLError}
+
+// ScenarioHeader forces a scenario for a single request, overriding the server-wide one.
+const ScenarioHeader = "X-Mock-Scenario"
+
+const defaultDelay = 15 * time.Second
+
+// Options configures a Server.
+type Options struct {
+	// Scenario is the initial server-wide scenario. Defaults to Healthy.
+	Scenario Scenario
+	// Delay is how long the Slow scenario waits before answering. Defaults to 15s.
+	Delay time.Duration
+	// Validators is the validator set served by every API family. Defaults to DefaultValidators(100).
+	Validators []Validator
+	// Logger receives one line per request when set.
+	Logger *log.Logger
+}
+
+// Server is an http.Handler that impersonates the upstream providers.
+type Server struct {
+	mu         sync.RWMutex
+	scenario   Scenario
+	delay      time.Duration
+	validators []Validator
+	logger     *log.Logger
+	requests   atomic.Int64
+}
+
+// New returns a Server configured by opts.
+func New(opts Options) *Server {
+	if opts.Scenario == "" {
+		opts.Scenario = Healthy
+	}
+	if opts.Delay == 0 {
+		opts.Delay = defaultDelay
+	}
+	if opts.Validators == nil {
+		opts.Validators = DefaultValidators(100)
+	}
+
+	return &Server{
+		scenario:   opts.Scenario,
+		delay:      opts.Delay,
+		validators: opts.Validators,
+		logger:     opts.Logger,
+	}
+}
+
+// NewHTTPTestServer starts an httptest.Server backed by a new Server.
+// The caller is responsible for closing the returned httptest.Server.
+func NewHTTPTestServer(opts Options) (*httptest.Server, *Server) {
+	s := New(opts)
+
+	return httptest.NewServer(s), s
+}
+
+// SetScenario switches the server-wide scenario.
+func (s *Server) SetScenario(scenario Scenario) error {
+	if !validScenario(scenario) {
+		return fmt.Errorf("unknown scenario: %s", scenario)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scenario = scenario
+
+	return nil
+}
+
+// Scenario returns the current server-wide scenario.
+func (s *Server) Scenario() Scenario {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.scenario
+}
+
+// SetValidators replaces the validator set served by every API family.
+func (s *Server) SetValidators(validators []Validator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.validators = validators
+}
+
+// Requests returns the number of provider requests served so far, excluding /_mock endpoints.
+func (s *Server) Requests() int64 {
+	return s.requests.Load()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/_mock/") {
+		s.serveControl(w, r)
+		return
+	}
+
+	s.requests.Add(1)
+
+	scenario := s.Scenario()
+	if forced := Scenario(r.Header.Get(ScenarioHeader)); forced != "" {
+		scenario = forced
+	}
+
+	if s.logger != nil {
+		s.logger.Printf("%s %s (%s)", r.Method, r.URL.RequestURI(), scenario)
+	}
+
+	switch scenario {
+	case Healthy:
+	case Slow:
+		s.mu.RLock()
+		delay := s.delay
+		s.mu.RUnlock()
+
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	case ServerError:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal server error"})
+		return
+	case RateLimited:
+		w.Header().Set("Retry-After", "1")
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "too many requests"})
+		return
+	case HTMLError:
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadGateway)
+		io.WriteString(w, "<html><head><title>502 Bad Gateway</title></head><body><center><h1>502 Bad Gateway</h1></center></body></html>\n")
+		return
+	case TruncatedJSON:
+		rec := httptest.NewRecorder()
+		s.route(rec, r)
+		body := rec.Body.Bytes()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(rec.Code)
+		w.Write(body[:len(body)/2])
+		return
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("unknown scenario: %s", scenario)})
+		return
+	}
+
+	s.route(w, r)
+}
+
+// route dispatches a request to the API family that owns it.
+func (s *Server) route(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	validators := s.validators
+	s.mu.RUnlock()
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/cosmos/staking/v1beta1/validators"):
+		serveCosmosValidators(w, validators)
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/cosmos/staking/v1beta1/pool"):
+		serveCosmosPool(w, validators)
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/eth/v1/beacon/states/head/validators"):
+		serveBeaconValidators(w, validators)
+	case r.Method == http.MethodGet && (strings.HasSuffix(path, "/tip") || strings.HasSuffix(path, "/pool_list") ||
+		strings.HasSuffix(path, "/pool_history") || strings.HasSuffix(path, "/epoch_info") || strings.Contains(path, "/epochs/") ||
+		strings.HasSuffix(path, "/pools/extended") || strings.HasSuffix(path, "/pools/retired") ||
+		(strings.Contains(path, "/pools/") && strings.HasSuffix(path, "/history"))):
+		serveCardano(w, r, path, validators)
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/v1/accounts/0x1/resource/0x1::stake::ValidatorSet"):
+		serveAptosValidatorSet(w, validators)
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/v1/realtime/participation/validators"):
+		serveAlgorandValidators(w, validators)
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/v2/ledger/supply"):
+		serveAlgodSupply(w, validators)
+	case r.Method == http.MethodGet && strings.Contains(path, "/v2/accounts/"):
+		serveAlgodAccount(w, path, validators)
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/api/v1/network/nodes"):
+		serveHederaNodes(w, r, validators)
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/stake"):
+		serveMultiversXStake(w, validators)
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/nodes") && !strings.HasSuffix(path, "/thorchain/nodes"):
+		serveMultiversXNodes(w, r, validators)
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/mainnet/api/api/validators"):
+		serveMinaValidators(w, r, validators)
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/api/v1/validators"):
+		serveExplorersGuruValidators(w, validators)
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/thorchain/nodes"):
+		serveThorchainNodes(w, validators)
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/bnb-staking/v1/validator/all"):
+		serveBscValidators(w, r, validators)
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/validators"):
+		serveCometBFTValidators(w, r, validators)
+	case r.Method == http.MethodPost && strings.Contains(path, "/subgraphs/"):
+		serveGraphQL(w, r, validators)
+	case r.Method == http.MethodPost:
+		serveJSONRPC(w, r, validators)
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no mock for " + r.Method + " " + r.URL.Path})
+	}
+}
+
+// serveControl handles the /_mock endpoints used to drive the server while it is running.
+func (s *Server) serveControl(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/_mock/scenario":
+		if r.Method == http.MethodPut || r.Method == http.MethodPost {
+			name := r.URL.Query().Get("name")
+			if name == "" {
+				body, _ := io.ReadAll(r.Body)
+				name = strings.TrimSpace(string(body))
+			}
+			if err := s.SetScenario(Scenario(name)); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"scenario": string(s.Scenario())})
+	case "/_mock/stats":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"scenario": s.Scenario(),
+			"requests": s.Requests(),
+		})
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown control endpoint"})
+	}
+}
+
+func validScenario(scenario Scenario) bool {
+	for _, s := range Scenarios {
+		if s == scenario {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
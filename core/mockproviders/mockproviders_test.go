@@ -0,0 +1,194 @@
+package mockproviders_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/chains"
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/mockproviders"
+)
+
+// mockDeps starts a mock server with opts and returns dependencies reading the given endpoints,
+// relative to its URL, from the environment as the server does.
+func mockDeps(t *testing.T, opts mockproviders.Options, endpoints map[string]string) (chains.Deps, *mockproviders.Server) {
+	t.Helper()
+
+	ts, server := mockproviders.NewHTTPTestServer(opts)
+	t.Cleanup(ts.Close)
+
+	for name, path := range endpoints {
+		t.Setenv(chains.EndpointEnvPrefix+name, ts.URL+path)
+	}
+
+	deps := chains.DepsFromEnv()
+	deps.Logger = log.New(io.Discard, "", 0)
+
+	return deps, server
+}
+
+func metrics(report chains.Report) map[string]int {
+	values := make(map[string]int, len(report.Metrics))
+	for _, m := range report.Metrics {
+		values[m.Name] = m.Value
+	}
+
+	return values
+}
+
+func TestCosmos(t *testing.T) {
+	deps, server := mockDeps(t, mockproviders.Options{}, map[string]string{"COSMOS": "/cosmoshub"})
+
+	report, err := chains.Cosmos(context.Background(), deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The 100 default validators hold 100 down to 1 units, the top 19 holding more than a third.
+	if report.Coefficient != 19 {
+		t.Errorf("coefficient = %d, want 19", report.Coefficient)
+	}
+	if got := metrics(report); got["validators"] != 19 || got["entities"] != 19 {
+		t.Errorf("metrics = %v, want validators and entities of 19", got)
+	}
+	if server.Requests() == 0 {
+		t.Error("no request reached the mock server")
+	}
+}
+
+func TestBase(t *testing.T) {
+	deps, _ := mockDeps(t, mockproviders.Options{}, map[string]string{"ETHEREUM_RPC": ""})
+
+	report, err := chains.Base(context.Background(), deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int{"sequencer": 1, "challenger": 2, "proposer": 1, "upgrade": 2}
+	got := metrics(report)
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("metric %s = %d, want %d", name, got[name], value)
+		}
+	}
+
+	// Only the even blocks carry a batch of the batcher registered in the SystemConfig.
+	if m := report.Metrics[0]; m.Name != "sequencer" || !strings.HasPrefix(m.Methodology, "50 batches ") {
+		t.Errorf("sequencer methodology = %q, want 50 batches", m.Methodology)
+	}
+}
+
+func TestPlume(t *testing.T) {
+	deps, _ := mockDeps(t, mockproviders.Options{}, map[string]string{"PLUME": "", "ETHEREUM_RPC": ""})
+	deps.Settings["plume_batch_inbox"] = "0x000000000000000000000000000000000000b0c5"
+	deps.Settings["plume_block_window"] = "30"
+
+	report, err := chains.Plume(context.Background(), deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both batch posters of ArbAggregator post a batch every other block.
+	if m := report.Metrics[0]; m.Name != "sequencer" || m.Value != 1 || !strings.HasPrefix(m.Methodology, "30 batches ") {
+		t.Errorf("sequencer = %+v, want 30 batches", m)
+	}
+	if got := metrics(report)["upgrade"]; got != 2 {
+		t.Errorf("upgrade = %d, want 2", got)
+	}
+}
+
+func TestScenarios(t *testing.T) {
+	for _, scenario := range []mockproviders.Scenario{mockproviders.ServerError, mockproviders.RateLimited, mockproviders.TruncatedJSON, mockproviders.HTMLError} {
+		t.Run(string(scenario), func(t *testing.T) {
+			deps, _ := mockDeps(t, mockproviders.Options{Scenario: scenario}, map[string]string{"COSMOS": "/cosmoshub"})
+
+			if _, err := chains.Cosmos(context.Background(), deps); err == nil {
+				t.Errorf("Cosmos succeeded with scenario %s, want error", scenario)
+			}
+		})
+	}
+}
+
+func TestScenarioControl(t *testing.T) {
+	ts, server := mockproviders.NewHTTPTestServer(mockproviders.Options{})
+	t.Cleanup(ts.Close)
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/_mock/scenario?name=429", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || server.Scenario() != mockproviders.RateLimited {
+		t.Fatalf("switching scenario: status %d, scenario %s", resp.StatusCode, server.Scenario())
+	}
+
+	resp, err = ts.Client().Get(ts.URL + "/cosmoshub/cosmos/staking/v1beta1/pool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusTooManyRequests)
+	}
+
+	// A scenario forced by header applies to that request only.
+	req, err = http.NewRequest(http.MethodGet, ts.URL+"/cosmoshub/cosmos/staking/v1beta1/pool", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(mockproviders.ScenarioHeader, string(mockproviders.Healthy))
+	resp, err = ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status with %s header = %d, want %d", mockproviders.ScenarioHeader, resp.StatusCode, http.StatusOK)
+	}
+
+	if err := server.SetScenario("unknown"); err == nil {
+		t.Error("SetScenario(unknown) succeeded, want error")
+	}
+}
+
+func TestCardano(t *testing.T) {
+	deps, server := mockDeps(t, mockproviders.Options{}, map[string]string{"CARDANO": ""})
+	deps.Settings["cardano_source"] = "koios"
+
+	report, err := chains.Cardano(context.Background(), deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The retired pool holds as much as the largest pool in the previous epoch.
+	want := map[string]int{"operators": 30, "previous_epoch_pools": 30, "previous_epoch_operators": 30}
+	if report.Coefficient != 30 {
+		t.Errorf("coefficient = %d, want 30", report.Coefficient)
+	}
+	got := metrics(report)
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("metric %s = %d, want %d", name, got[name], value)
+		}
+	}
+
+	// The previous epoch is not requested again within the same epoch.
+	first := server.Requests()
+	again, err := chains.Cardano(context.Background(), deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := server.Requests() - first; n >= first/2 {
+		t.Errorf("second report made %d requests, first %d", n, first)
+	}
+	if got := metrics(again); got["previous_epoch_pools"] != 30 {
+		t.Errorf("cached previous_epoch_pools = %d, want 30", got["previous_epoch_pools"])
+	}
+}
@@ -0,0 +1,591 @@
+package mockproviders
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// beaconValidatorBalance is the effective balance of a single beacon chain validator in gwei.
+const beaconValidatorBalance = 32_000_000_000
+
+// serveCosmosValidators answers GET <base>/cosmos/staking/v1beta1/validators.
+func serveCosmosValidators(w http.ResponseWriter, validators []Validator) {
+	type description struct {
+		Moniker  string `json:"moniker"`
+		Identity string `json:"identity"`
+	}
+	type validator struct {
+		OperatorAddress string      `json:"operator_address"`
+		Jailed          bool        `json:"jailed"`
+		Status          string      `json:"status"`
+		Tokens          string      `json:"tokens"`
+		DelegatorShares string      `json:"delegator_shares"`
+		Description     description `json:"description"`
+	}
+
+	list := make([]validator, 0, len(validators))
+	for _, v := range validators {
+		list = append(list, validator{
+			OperatorAddress: v.Address,
+			Status:          "BOND_STATUS_BONDED",
+			Tokens:          v.Stake.String(),
+			DelegatorShares: v.Stake.String() + ".000000000000000000",
+			Description:     description{Moniker: v.Name, Identity: v.Identity},
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"validators": list,
+		"pagination": map[string]interface{}{
+			"next_key": nil,
+			"total":    strconv.Itoa(len(list)),
+		},
+	})
+}
+
+// serveCosmosPool answers GET <base>/cosmos/staking/v1beta1/pool.
+func serveCosmosPool(w http.ResponseWriter, validators []Validator) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"pool": map[string]string{
+			"not_bonded_tokens": "0",
+			"bonded_tokens":     totalStake(validators).String(),
+		},
+	})
+}
+
+// serveBeaconValidators answers GET <base>/eth/v1/beacon/states/head/validators. Every mock validator
+// becomes an operator running one 32 ETH validator per 1e9 units of stake, all sharing the
+// withdrawal credentials of that operator.
+func serveBeaconValidators(w http.ResponseWriter, validators []Validator) {
+	type validator struct {
+		Pubkey                string `json:"pubkey"`
+		WithdrawalCredentials string `json:"withdrawal_credentials"`
+		EffectiveBalance      string `json:"effective_balance"`
+	}
+	type entry struct {
+		Index     string    `json:"index"`
+		Balance   string    `json:"balance"`
+		Status    string    `json:"status"`
+		Validator validator `json:"validator"`
+	}
+
+	var list []entry
+	for i, v := range validators {
+		count := new(big.Int).Div(v.Stake, big.NewInt(1_000_000_000)).Int64()
+		for j := int64(0); j < count; j++ {
+			list = append(list, entry{
+				Index:   strconv.Itoa(len(list)),
+				Balance: strconv.Itoa(beaconValidatorBalance),
+				Status:  "active_ongoing",
+				Validator: validator{
+					Pubkey:                fmt.Sprintf("0x%096x", len(list)+1),
+					WithdrawalCredentials: fmt.Sprintf("0x010000000000000000000000%040x", i+1),
+					EffectiveBalance:      strconv.Itoa(beaconValidatorBalance),
+				},
+			})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"execution_optimistic": false,
+		"finalized":            false,
+		"data":                 list,
+	})
+}
+
+// serveBscValidators answers GET <base>/bnb-staking/v1/validator/all?limit=N&offset=M of the BNB
+// staking API, with stakes in wei.
+func serveBscValidators(w http.ResponseWriter, r *http.Request, validators []Validator) {
+	type validator struct {
+		OperatorAddress string `json:"operatorAddress"`
+		Moniker         string `json:"moniker"`
+		TotalStaked     string `json:"totalStaked"`
+	}
+
+	limit, offset := queryInt(r, "limit", 50), queryInt(r, "offset", 0)
+	if limit < 1 {
+		limit = 50
+	}
+	if offset < 0 || offset > len(validators) {
+		offset = len(validators)
+	}
+	end := offset + limit
+	if end > len(validators) {
+		end = len(validators)
+	}
+
+	list := make([]validator, 0, end-offset)
+	for i, v := range validators[offset:end] {
+		list = append(list, validator{
+			OperatorAddress: fmt.Sprintf("0x%040x", bscOperatorBase+offset+i+1),
+			Moniker:         v.Name,
+			TotalStaked:     new(big.Int).Mul(v.Stake, big.NewInt(1e9)).String(),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"code": 2000,
+		"data": map[string]interface{}{
+			"total":      len(validators),
+			"validators": list,
+		},
+	})
+}
+
+// serveThorchainNodes answers GET <base>/thorchain/nodes of THORNode. Validators sharing an Identity
+// share a node operator and bond provider. Every tenth validator is a churn-eligible standby node.
+func serveThorchainNodes(w http.ResponseWriter, validators []Validator) {
+	type provider struct {
+		BondAddress string `json:"bond_address"`
+		Bond        string `json:"bond"`
+	}
+	type node struct {
+		NodeAddress         string `json:"node_address"`
+		NodeOperatorAddress string `json:"node_operator_address"`
+		Status              string `json:"status"`
+		TotalBond           string `json:"total_bond"`
+		BondProviders       struct {
+			Providers []provider `json:"providers"`
+		} `json:"bond_providers"`
+		PreflightStatus struct {
+			Status string `json:"status"`
+		} `json:"preflight_status"`
+	}
+
+	list := make([]node, 0, len(validators))
+	for i, v := range validators {
+		operator := "thor1operator" + v.Address
+		if v.Identity != "" {
+			operator = "thor1operator" + v.Identity
+		}
+
+		n := node{NodeAddress: "thor1" + v.Address, NodeOperatorAddress: operator, Status: "Active", TotalBond: v.Stake.String()}
+		n.BondProviders.Providers = []provider{{BondAddress: operator, Bond: v.Stake.String()}}
+		n.PreflightStatus.Status = "Ready"
+		if i%10 == 9 {
+			n.Status = "Standby"
+		}
+		list = append(list, n)
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+// serveExplorersGuruValidators answers GET <base>/api/v1/validators of explorers.guru, which reports
+// voting power as a percentage of the total. The last validator is jailed.
+func serveExplorersGuruValidators(w http.ResponseWriter, validators []Validator) {
+	type validator struct {
+		OperatorAddress    string  `json:"operatorAddress"`
+		Jailed             bool    `json:"jailed"`
+		VotingPowerPercent float64 `json:"votingPowerPercent"`
+	}
+
+	total := new(big.Float).SetInt(totalStake(validators))
+	list := make([]validator, 0, len(validators))
+	for i, v := range validators {
+		percent, _ := new(big.Float).Quo(new(big.Float).SetInt(v.Stake), total).Float64()
+		list = append(list, validator{
+			OperatorAddress:    v.Address,
+			Jailed:             i == len(validators)-1,
+			VotingPowerPercent: percent * 100,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+// serveCardano answers the Koios endpoints /tip, /pool_list, /epoch_info and /pool_history and the
+// Blockfrost endpoints /epochs/latest, /epochs/{n}, /pools/extended, /pools/retired and
+// /pools/{id}/history. Validators are stake pools with their stake in lovelace, grouped by Identity,
+// and held the same stake in the previous epoch, alongside a pool retired in the current epoch
+// holding the stake of the first validator.
+func serveCardano(w http.ResponseWriter, r *http.Request, path string, validators []Validator) {
+	poolID := func(i int) string { return fmt.Sprintf("pool1mock%03d", i+1) }
+	const retiredPool = "pool1mockretired"
+	retiredStake := new(big.Int)
+	if len(validators) > 0 {
+		retiredStake = validators[0].Stake
+	}
+	previousTotal := new(big.Int).Set(retiredStake)
+	for _, v := range validators {
+		previousTotal.Add(previousTotal, v.Stake)
+	}
+	page := func(offset, limit int) (int, int) {
+		if offset < 0 || offset > len(validators) {
+			offset = len(validators)
+		}
+		end := offset + limit
+		if end > len(validators) {
+			end = len(validators)
+		}
+		return offset, end
+	}
+
+	switch {
+	case strings.HasSuffix(path, "/tip"):
+		writeJSON(w, http.StatusOK, []map[string]int{{"epoch_no": mockEpoch}})
+	case strings.HasSuffix(path, "/epochs/latest"):
+		writeJSON(w, http.StatusOK, map[string]int{"epoch": mockEpoch})
+	case strings.HasSuffix(path, "/epoch_info") && r.URL.Query().Get("_epoch_no") == strconv.Itoa(mockEpoch-1):
+		writeJSON(w, http.StatusOK, []map[string]string{{"active_stake": previousTotal.String()}})
+	case strings.HasSuffix(path, fmt.Sprintf("/epochs/%d", mockEpoch-1)):
+		writeJSON(w, http.StatusOK, map[string]string{"active_stake": previousTotal.String()})
+	case strings.HasSuffix(path, "/pool_list") && r.URL.Query().Get("pool_status") == "eq.retired":
+		writeJSON(w, http.StatusOK, []map[string]interface{}{{"pool_id_bech32": retiredPool, "pool_group": nil}})
+	case strings.HasSuffix(path, "/pools/retired"):
+		list := []map[string]interface{}{{"pool_id": retiredPool, "epoch": mockEpoch}}
+		if queryInt(r, "page", 1) > 1 {
+			list = list[:0]
+		}
+		writeJSON(w, http.StatusOK, list)
+	case strings.HasSuffix(path, "/pool_list"):
+		start, end := page(queryInt(r, "offset", 0), queryInt(r, "limit", 1000))
+		list := make([]map[string]interface{}, 0, end-start)
+		for i := start; i < end; i++ {
+			pool := map[string]interface{}{"pool_id_bech32": poolID(i), "active_stake": validators[i].Stake.String(), "pool_group": nil}
+			if validators[i].Identity != "" {
+				pool["pool_group"] = validators[i].Identity
+			}
+			list = append(list, pool)
+		}
+		writeJSON(w, http.StatusOK, list)
+	case strings.HasSuffix(path, "/pools/extended"):
+		start, end := page((queryInt(r, "page", 1)-1)*queryInt(r, "count", 100), queryInt(r, "count", 100))
+		list := make([]map[string]string, 0, end-start)
+		for i := start; i < end; i++ {
+			list = append(list, map[string]string{"pool_id": poolID(i), "active_stake": validators[i].Stake.String()})
+		}
+		writeJSON(w, http.StatusOK, list)
+	default:
+		// Koios passes the pool as a query parameter, Blockfrost in the path.
+		id := r.URL.Query().Get("_pool_bech32")
+		if id == "" {
+			id = strings.TrimSuffix(strings.TrimPrefix(path[strings.LastIndex(path, "/pools/"):], "/pools/"), "/history")
+		}
+		if id == retiredPool {
+			writeJSON(w, http.StatusOK, []map[string]interface{}{
+				{"epoch_no": mockEpoch - 1, "epoch": mockEpoch - 1, "active_stake": retiredStake.String()},
+			})
+			return
+		}
+		for i, v := range validators {
+			if poolID(i) == id {
+				writeJSON(w, http.StatusOK, []map[string]interface{}{
+					{"epoch_no": mockEpoch - 1, "epoch": mockEpoch - 1, "active_stake": v.Stake.String()},
+				})
+				return
+			}
+		}
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown pool " + id})
+	}
+}
+
+// serveMinaValidators answers GET <base>/mainnet/api/api/validators/?page=N&size=M of minascan,
+// with stakes in MINA. Validators sharing an Identity share a block producer key.
+func serveMinaValidators(w http.ResponseWriter, r *http.Request, validators []Validator) {
+	type validator struct {
+		Pk           string  `json:"pk"`
+		Name         string  `json:"name"`
+		AmountStaked float64 `json:"amountStaked"`
+	}
+
+	size := queryInt(r, "size", 50)
+	if size < 1 {
+		size = 50
+	}
+	start := queryInt(r, "page", 0) * size
+	if start < 0 || start > len(validators) {
+		start = len(validators)
+	}
+	end := start + size
+	if end > len(validators) {
+		end = len(validators)
+	}
+
+	list := make([]validator, 0, end-start)
+	for _, v := range validators[start:end] {
+		pk := "B62q" + v.Address
+		if v.Identity != "" {
+			pk = "B62q" + v.Identity
+		}
+		amount, _ := new(big.Float).Quo(new(big.Float).SetInt(v.Stake), big.NewFloat(1e9)).Float64()
+		list = append(list, validator{Pk: pk, Name: v.Name, AmountStaked: amount})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"content":       list,
+		"totalPages":    (len(validators) + size - 1) / size,
+		"totalElements": len(validators),
+	})
+}
+
+// serveMultiversXStake answers GET <base>/stake of the MultiversX API.
+func serveMultiversXStake(w http.ResponseWriter, validators []Validator) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"totalValidators": len(validators),
+		"totalStaked":     new(big.Int).Mul(totalStake(validators), big.NewInt(1e9)).String(),
+	})
+}
+
+// serveMultiversXNodes answers GET <base>/nodes?from=N&size=M of the MultiversX API with one eligible
+// node per validator and its stake in 10^-18 EGLD. Nodes report their Identity; every other node
+// without one is run by a staking provider.
+func serveMultiversXNodes(w http.ResponseWriter, r *http.Request, validators []Validator) {
+	type node struct {
+		Bls      string `json:"bls"`
+		Identity string `json:"identity,omitempty"`
+		Provider string `json:"provider,omitempty"`
+		Locked   string `json:"locked"`
+		Status   string `json:"status"`
+	}
+
+	size := queryInt(r, "size", 25)
+	if size < 1 {
+		size = 25
+	}
+	start := queryInt(r, "from", 0)
+	if start < 0 || start > len(validators) {
+		start = len(validators)
+	}
+	end := start + size
+	if end > len(validators) {
+		end = len(validators)
+	}
+
+	list := make([]node, 0, end-start)
+	for i, v := range validators[start:end] {
+		n := node{
+			Bls:      fmt.Sprintf("%096x", start+i+1),
+			Identity: v.Identity,
+			Locked:   new(big.Int).Mul(v.Stake, big.NewInt(1e9)).String(),
+			Status:   "eligible",
+		}
+		if n.Identity == "" && (start+i)%2 == 0 {
+			n.Provider = "erd1qqqqqqqqqqqqqprovider" + v.Address
+		}
+		list = append(list, n)
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+// serveHederaNodes answers GET <base>/api/v1/network/nodes of a Hedera mirror node, 25 nodes per page
+// with stakes in tinybars. A fifth of the stake of each node is not rewarded, and validators sharing
+// an Identity are hosted by the same organization.
+func serveHederaNodes(w http.ResponseWriter, r *http.Request, validators []Validator) {
+	const pageSize = 25
+
+	start := queryInt(r, "offset", 0)
+	if start < 0 || start > len(validators) {
+		start = len(validators)
+	}
+	end := start + pageSize
+	if end > len(validators) {
+		end = len(validators)
+	}
+
+	list := make([]map[string]interface{}, 0, end-start)
+	for i, v := range validators[start:end] {
+		organization := v.Name
+		if v.Identity != "" {
+			organization = v.Identity
+		}
+
+		stake := new(big.Int).Mul(v.Stake, big.NewInt(100))
+		notRewarded := new(big.Int).Div(stake, big.NewInt(5))
+		list = append(list, map[string]interface{}{
+			"description":        "Hosted by " + organization + " | Nowhere",
+			"node_account_id":    fmt.Sprintf("0.0.%d", start+i+3),
+			"stake":              json.Number(stake.String()),
+			"stake_rewarded":     json.Number(new(big.Int).Sub(stake, notRewarded).String()),
+			"stake_not_rewarded": json.Number(notRewarded.String()),
+			"min_stake":          json.Number("0"),
+			"max_stake":          json.Number(new(big.Int).Mul(totalStake(validators), big.NewInt(100)).String()),
+		})
+	}
+
+	var next interface{}
+	if end < len(validators) {
+		next = fmt.Sprintf("/api/v1/network/nodes?offset=%d", end)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"nodes": list, "links": map[string]interface{}{"next": next}})
+}
+
+// serveAptosValidatorSet answers GET <base>/v1/accounts/0x1/resource/0x1::stake::ValidatorSet of an
+// Aptos fullnode. The last validator is joining in the next epoch and the one before it is leaving,
+// still counting towards the total voting power of the current epoch.
+func serveAptosValidatorSet(w http.ResponseWriter, validators []Validator) {
+	type validatorInfo struct {
+		Addr        string `json:"addr"`
+		VotingPower string `json:"voting_power"`
+	}
+
+	var (
+		active, pendingActive, pendingInactive []validatorInfo
+		totalVotingPower, totalJoiningPower    = new(big.Int), new(big.Int)
+	)
+	for i, v := range validators {
+		info := validatorInfo{Addr: fmt.Sprintf("0x%064x", i+1), VotingPower: v.Stake.String()}
+		switch {
+		case len(validators) > 2 && i == len(validators)-1:
+			pendingActive = append(pendingActive, info)
+			totalJoiningPower.Add(totalJoiningPower, v.Stake)
+		case len(validators) > 2 && i == len(validators)-2:
+			pendingInactive = append(pendingInactive, info)
+			totalVotingPower.Add(totalVotingPower, v.Stake)
+		default:
+			active = append(active, info)
+			totalVotingPower.Add(totalVotingPower, v.Stake)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"type": "0x1::stake::ValidatorSet",
+		"data": map[string]interface{}{
+			"consensus_scheme":    0,
+			"active_validators":   active,
+			"pending_active":      pendingActive,
+			"pending_inactive":    pendingInactive,
+			"total_voting_power":  totalVotingPower.String(),
+			"total_joining_power": totalJoiningPower.String(),
+		},
+	})
+}
+
+// algorandParticipant describes the participation of the i-th validator on Algorand: the last of
+// them has an expired participation key, every tenth has been idle for a while, which only algod
+// reports, and every fifth is not eligible for rewards.
+func algorandParticipant(i int, validators []Validator) (expired, idle, eligible bool) {
+	return i == len(validators)-1, i%10 == 9, i%5 != 4
+}
+
+// serveAlgorandValidators answers GET <base>/v1/realtime/participation/validators of the nodely
+// metrics API, with stakes in microalgos.
+func serveAlgorandValidators(w http.ResponseWriter, validators []Validator) {
+	list := make([]map[string]interface{}, 0, len(validators))
+	for i, v := range validators {
+		expired, _, eligible := algorandParticipant(i, validators)
+
+		expiresInDays, lastVotingRound := 30.0, mockBlockNumber+1_000_000
+		if expired {
+			expiresInDays, lastVotingRound = 0, mockBlockNumber-1
+		}
+
+		list = append(list, map[string]interface{}{
+			"address":           v.Address,
+			"stake_micro_algo":  v.Stake.Uint64(),
+			"rewards_eligible":  eligible,
+			"keytype":           "ed25519",
+			"as_of_round":       mockBlockNumber,
+			"last_voting_round": lastVotingRound,
+			"expires_in_days":   expiresInDays,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+// serveAlgodSupply answers GET <base>/v2/ledger/supply of algod, all the stake being online.
+func serveAlgodSupply(w http.ResponseWriter, validators []Validator) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"current_round": mockBlockNumber,
+		"online-money":  totalStake(validators).Uint64(),
+		"total-money":   new(big.Int).Mul(totalStake(validators), big.NewInt(2)).Uint64(),
+	})
+}
+
+// serveAlgodAccount answers GET <base>/v2/accounts/{address} of algod for the validators.
+func serveAlgodAccount(w http.ResponseWriter, path string, validators []Validator) {
+	address := path[strings.LastIndex(path, "/")+1:]
+	for i, v := range validators {
+		if v.Address != address {
+			continue
+		}
+
+		expired, idle, eligible := algorandParticipant(i, validators)
+		voteLastValid, lastHeartbeat := mockBlockNumber+1_000_000, mockBlockNumber-10
+		if expired {
+			voteLastValid = mockBlockNumber - 1
+		}
+		if idle {
+			lastHeartbeat = mockBlockNumber - 100_000
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"address":            v.Address,
+			"amount":             v.Stake.Uint64(),
+			"status":             "Online",
+			"incentive-eligible": eligible,
+			"last-heartbeat":     lastHeartbeat,
+			"round":              mockBlockNumber,
+			"participation":      map[string]interface{}{"vote-last-valid": voteLastValid},
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusNotFound, map[string]string{"message": "account not found"})
+}
+
+// serveCometBFTValidators answers GET <base>/validators?page=N&per_page=M of the CometBFT RPC.
+func serveCometBFTValidators(w http.ResponseWriter, r *http.Request, validators []Validator) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      -1,
+		"result":  cometBFTValidators(queryInt(r, "page", 1), queryInt(r, "per_page", 30), validators),
+	})
+}
+
+// cometBFTValidators returns a page of the result of the CometBFT validators method.
+func cometBFTValidators(page, perPage int, validators []Validator) interface{} {
+	type validator struct {
+		Address          string `json:"address"`
+		VotingPower      string `json:"voting_power"`
+		ProposerPriority string `json:"proposer_priority"`
+	}
+
+	if page < 1 {
+		page = 1
+	}
+	if perPage < 1 {
+		perPage = 30
+	}
+
+	start := (page - 1) * perPage
+	if start > len(validators) {
+		start = len(validators)
+	}
+	end := start + perPage
+	if end > len(validators) {
+		end = len(validators)
+	}
+
+	list := make([]validator, 0, end-start)
+	for _, v := range validators[start:end] {
+		list = append(list, validator{
+			Address:          v.Address,
+			VotingPower:      v.Stake.String(),
+			ProposerPriority: "0",
+		})
+	}
+
+	return map[string]interface{}{
+		"block_height": "1000000",
+		"validators":   list,
+		"count":        strconv.Itoa(len(list)),
+		"total":        strconv.Itoa(len(validators)),
+	}
+}
+
+func queryInt(r *http.Request, name string, fallback int) int {
+	n, err := strconv.Atoi(r.URL.Query().Get(name))
+	if err != nil {
+		return fallback
+	}
+
+	return n
+}
@@ -0,0 +1,130 @@
+package mockproviders
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/substrate"
+)
+
+const mockFinalizedHead = "0x00000000000000000000000000000000000000000000000000000000000f4240"
+
+// substrateStorage returns the storage served by the Substrate JSON-RPC methods, keyed by hex
+// encoded key without prefix: the active era, the paged exposure overview of every validator for
+// that era and the parent identity of validators with an Identity.
+func substrateStorage(validators []Validator) (map[string]string, []string) {
+	storage := make(map[string]string)
+
+	era := make([]byte, 4)
+	binary.LittleEndian.PutUint32(era, mockEpoch)
+	// ActiveEraInfo { index: u32, start: Option<u64> = None }
+	storage[hex.EncodeToString(substrate.StorageKey("Staking", "ActiveEra"))] = hex.EncodeToString(append(era, 0))
+
+	for _, v := range validators {
+		account := substrateAccountID(v.Address)
+
+		// PagedExposureMetadata { total: Compact<u128>, own: Compact<u128>, nominator_count: u32, page_count: u32 }
+		value := substrate.EncodeCompact(v.Stake)
+		value = append(value, substrate.EncodeCompact(v.Stake)...)
+		value = append(value, 0, 0, 0, 0, 1, 0, 0, 0)
+
+		key := substrate.StorageKey("Staking", "ErasStakersOverview", substrate.Twox64Concat(era), substrate.Twox64Concat(account))
+		storage[hex.EncodeToString(key)] = hex.EncodeToString(value)
+
+		if v.Identity != "" {
+			parent := substrateAccountID("parent:" + v.Identity)
+			// (AccountId, Data::None)
+			key := substrate.StorageKey("Identity", "SuperOf", substrate.Blake2_128Concat(account))
+			storage[hex.EncodeToString(key)] = hex.EncodeToString(append(parent, 0))
+		}
+	}
+
+	keys := make([]string, 0, len(storage))
+	for key := range storage {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return storage, keys
+}
+
+// substrateAccountID derives a deterministic 32 byte account ID from a mock address.
+func substrateAccountID(address string) []byte {
+	id := blake2b.Sum256([]byte(address))
+	return id[:]
+}
+
+// substrateRPC answers the storage methods of the Substrate JSON-RPC.
+func substrateRPC(req rpcRequest, validators []Validator) (interface{}, *rpcError) {
+	storage, keys := substrateStorage(validators)
+
+	var params []interface{}
+	for _, raw := range req.positional() {
+		var p interface{}
+		_ = json.Unmarshal(raw, &p)
+		params = append(params, p)
+	}
+	param := func(i int) string {
+		if i < len(params) {
+			if s, ok := params[i].(string); ok {
+				return strings.ToLower(strings.TrimPrefix(s, "0x"))
+			}
+		}
+		return ""
+	}
+
+	switch req.Method {
+	case "chain_getFinalizedHead":
+		return mockFinalizedHead, nil
+	case "state_getStorage":
+		if value, ok := storage[param(0)]; ok {
+			return "0x" + value, nil
+		}
+		return nil, nil
+	case "state_getKeysPaged":
+		prefix, start := param(0), param(2)
+		count := 1000
+		if len(params) > 1 {
+			if f, ok := params[1].(float64); ok {
+				count = int(f)
+			}
+		}
+
+		page := []string{}
+		for _, key := range keys {
+			if strings.HasPrefix(key, prefix) && key > start && len(page) < count {
+				page = append(page, "0x"+key)
+			}
+		}
+		return page, nil
+	case "state_queryStorageAt":
+		var requested []string
+		if len(params) > 0 {
+			list, _ := params[0].([]interface{})
+			for _, k := range list {
+				if s, ok := k.(string); ok {
+					requested = append(requested, s)
+				}
+			}
+		}
+
+		changes := make([][2]*string, 0, len(requested))
+		for _, key := range requested {
+			key := key
+			var value *string
+			if v, ok := storage[strings.ToLower(strings.TrimPrefix(key, "0x"))]; ok {
+				v = "0x" + v
+				value = &v
+			}
+			changes = append(changes, [2]*string{&key, value})
+		}
+		return []map[string]interface{}{{"block": mockFinalizedHead, "changes": changes}}, nil
+	default:
+		return nil, &rpcError{Code: -32601, Message: "the method " + req.Method + " does not exist/is not available"}
+	}
+}
@@ -0,0 +1,56 @@
+package mockproviders
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Validator is a single entry of the validator set served by the mock providers.
+type Validator struct {
+	Address string
+	Name    string
+	// Identity optionally names the operator of the validator. Validators sharing an Identity are
+	// reported with the same Keybase identity by the Cosmos API and the same parent identity by the Substrate RPC.
+	Identity string
+	// Stake is expressed in the smallest unit of the impersonated chain.
+	Stake *big.Int
+}
+
+// DefaultValidators returns n validators with linearly decreasing stake, the first one holding
+// n * 1e9 units and the last one 1e9 units. The resulting Nakamoto coefficient is deterministic,
+// which makes the set suitable for asserting on end-to-end results.
+func DefaultValidators(n int) []Validator {
+	validators := make([]Validator, 0, n)
+	unit := big.NewInt(1_000_000_000)
+
+	for i := 0; i < n; i++ {
+		validators = append(validators, Validator{
+			Address: fmt.Sprintf("mockvaloper1%038x", i+1),
+			Name:    fmt.Sprintf("mock-validator-%03d", i+1),
+			Stake:   new(big.Int).Mul(big.NewInt(int64(n-i)), unit),
+		})
+	}
+
+	return validators
+}
+
+// totalStake returns the sum of the stake of all validators.
+func totalStake(validators []Validator) *big.Int {
+	total := big.NewInt(0)
+	for _, v := range validators {
+		total.Add(total, v.Stake)
+	}
+
+	return total
+}
+
+// basisPoints returns the share of stake as parts of 10000, the unit Sui uses for voting power.
+func basisPoints(stake, total *big.Int) int64 {
+	if total.Sign() == 0 {
+		return 0
+	}
+
+	bp := new(big.Int).Mul(stake, big.NewInt(10_000))
+
+	return bp.Div(bp, total).Int64()
+}
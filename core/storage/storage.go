@@ -0,0 +1,112 @@
+// Package storage persists snapshots of the chain state so that they survive restarts.
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/chains"
+)
+
+// Snapshot is the persisted form of the chain state.
+type Snapshot struct {
+	SavedAt time.Time         `json:"saved_at"`
+	Chains  chains.ChainState `json:"chains"`
+}
+
+// FileStore stores a single snapshot as a JSON file.
+type FileStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileStore returns a FileStore backed by the file at path.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Path returns the path of the backing file.
+func (s *FileStore) Path() string {
+	return s.path
+}
+
+// Save replaces the stored snapshot. The file is written next to its final location and renamed
+// into place, so an interrupted write never leaves a truncated snapshot behind.
+func (s *FileStore) Save(snapshot Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal snapshot: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create temporary snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close snapshot: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("rename snapshot: %w", err)
+	}
+
+	return nil
+}
+
+// Load returns the stored snapshot. It returns an error wrapping fs.ErrNotExist if nothing has been saved yet.
+func (s *FileStore) Load() (Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return Snapshot{}, fmt.Errorf("unmarshal snapshot %s: %w", s.path, err)
+	}
+
+	return snapshot, nil
+}
+
+// CheckWritable verifies that a snapshot could be written, without touching the stored one.
+func (s *FileStore) CheckWritable() error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.probe")
+	if err != nil {
+		return err
+	}
+
+	name := tmp.Name()
+	if err := tmp.Close(); err != nil {
+		os.Remove(name)
+		return err
+	}
+
+	return os.Remove(name)
+}
+
+// IsNotExist reports whether err indicates that no snapshot has been saved yet.
+func IsNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
@@ -0,0 +1,118 @@
+// Package substrate contains the storage key hashing and SCALE decoding needed to read staking
+// data from the JSON-RPC of Substrate based chains such as Polkadot and Avail.
+package substrate
+
+import (
+	"encoding/binary"
+	"math/bits"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	prime64v1 uint64 = 11400714785074694791
+	prime64v2 uint64 = 14029467366897019727
+	prime64v3 uint64 = 1609587929392839161
+	prime64v4 uint64 = 9650029242287828579
+	prime64v5 uint64 = 2870177450012600261
+)
+
+// Twox128 returns the 128 bit xxHash of data used for pallet and storage item prefixes.
+func Twox128(data []byte) []byte {
+	out := make([]byte, 16)
+	binary.LittleEndian.PutUint64(out[:8], xxh64(data, 0))
+	binary.LittleEndian.PutUint64(out[8:], xxh64(data, 1))
+
+	return out
+}
+
+// Twox64Concat returns the 64 bit xxHash of data followed by data itself.
+func Twox64Concat(data []byte) []byte {
+	out := make([]byte, 8, 8+len(data))
+	binary.LittleEndian.PutUint64(out, xxh64(data, 0))
+
+	return append(out, data...)
+}
+
+// Blake2_128Concat returns the 128 bit BLAKE2b hash of data followed by data itself.
+func Blake2_128Concat(data []byte) []byte {
+	h, _ := blake2b.New(16, nil)
+	h.Write(data)
+
+	return append(h.Sum(nil), data...)
+}
+
+// StorageKey returns the prefix of a storage item, twox128(pallet) ++ twox128(item), followed by
+// the already hashed map keys.
+func StorageKey(pallet, item string, hashedKeys ...[]byte) []byte {
+	key := append(Twox128([]byte(pallet)), Twox128([]byte(item))...)
+	for _, k := range hashedKeys {
+		key = append(key, k...)
+	}
+
+	return key
+}
+
+// xxh64 implements the 64 bit xxHash algorithm.
+func xxh64(data []byte, seed uint64) uint64 {
+	n := len(data)
+	var h uint64
+
+	if n >= 32 {
+		v1 := seed + prime64v1 + prime64v2
+		v2 := seed + prime64v2
+		v3 := seed
+		v4 := seed - prime64v1
+		for ; len(data) >= 32; data = data[32:] {
+			v1 = xxh64Round(v1, binary.LittleEndian.Uint64(data[0:]))
+			v2 = xxh64Round(v2, binary.LittleEndian.Uint64(data[8:]))
+			v3 = xxh64Round(v3, binary.LittleEndian.Uint64(data[16:]))
+			v4 = xxh64Round(v4, binary.LittleEndian.Uint64(data[24:]))
+		}
+
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxh64MergeRound(h, v1)
+		h = xxh64MergeRound(h, v2)
+		h = xxh64MergeRound(h, v3)
+		h = xxh64MergeRound(h, v4)
+	} else {
+		h = seed + prime64v5
+	}
+
+	h += uint64(n)
+
+	for ; len(data) >= 8; data = data[8:] {
+		h ^= xxh64Round(0, binary.LittleEndian.Uint64(data))
+		h = bits.RotateLeft64(h, 27)*prime64v1 + prime64v4
+	}
+	if len(data) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(data)) * prime64v1
+		h = bits.RotateLeft64(h, 23)*prime64v2 + prime64v3
+		data = data[4:]
+	}
+	for _, b := range data {
+		h ^= uint64(b) * prime64v5
+		h = bits.RotateLeft64(h, 11) * prime64v1
+	}
+
+	h ^= h >> 33
+	h *= prime64v2
+	h ^= h >> 29
+	h *= prime64v3
+	h ^= h >> 32
+
+	return h
+}
+
+func xxh64Round(acc, input uint64) uint64 {
+	acc += input * prime64v2
+	acc = bits.RotateLeft64(acc, 31)
+
+	return acc * prime64v1
+}
+
+func xxh64MergeRound(acc, val uint64) uint64 {
+	acc ^= xxh64Round(0, val)
+
+	return acc*prime64v1 + prime64v4
+}
@@ -0,0 +1,60 @@
+package substrate
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestXXH64(t *testing.T) {
+	tests := []struct {
+		data string
+		want uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"abc", 0x44bc2cf5ad770999},
+	}
+
+	for _, tt := range tests {
+		if got := xxh64([]byte(tt.data), 0); got != tt.want {
+			t.Errorf("xxh64(%q) = %#x, want %#x", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestTwox128(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"System", "26aa394eea5630e07c48ae0c9558cef7"},
+		{"Account", "b99d880ec681799c0cf30e8886371da9"},
+		{"Staking", "5f3e4907f716ac89b6347d15ececedca"},
+		{"ActiveEra", "487df464e44a534ba6b0cbb32407b587"},
+		{"Number", "02a5c1b19ab7a04f536c519aca4983ac"},
+	}
+
+	for _, tt := range tests {
+		if got := hex.EncodeToString(Twox128([]byte(tt.name))); got != tt.want {
+			t.Errorf("Twox128(%q) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStorageKey(t *testing.T) {
+	key := StorageKey("Staking", "ActiveEra")
+	want := "5f3e4907f716ac89b6347d15ececedca487df464e44a534ba6b0cbb32407b587"
+	if got := hex.EncodeToString(key); got != want {
+		t.Errorf("StorageKey(Staking, ActiveEra) = %s, want %s", got, want)
+	}
+
+	era := []byte{1, 0, 0, 0}
+	key = StorageKey("Staking", "ErasTotalStake", Twox64Concat(era))
+	prefix := hex.EncodeToString(Twox128([]byte("Staking"))) + hex.EncodeToString(Twox128([]byte("ErasTotalStake")))
+	if got := hex.EncodeToString(key); got[:64] != prefix || len(key) != 32+8+len(era) {
+		t.Errorf("StorageKey with hashed key = %s", got)
+	}
+	if got := key[len(key)-len(era):]; hex.EncodeToString(got) != hex.EncodeToString(era) {
+		t.Errorf("Twox64Concat does not end with the key: %x", got)
+	}
+}
@@ -0,0 +1,178 @@
+package substrate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// DecodeCompact decodes a SCALE compact encoded unsigned integer from the start of b and returns
+// it together with the number of bytes read.
+func DecodeCompact(b []byte) (*big.Int, int, error) {
+	if len(b) == 0 {
+		return nil, 0, errors.New("compact: empty input")
+	}
+
+	var size int
+	switch b[0] & 0b11 {
+	case 0b00:
+		return big.NewInt(int64(b[0] >> 2)), 1, nil
+	case 0b01:
+		size = 2
+	case 0b10:
+		size = 4
+	default:
+		size = int(b[0]>>2) + 5
+	}
+	if len(b) < size {
+		return nil, 0, errors.New("compact: input too short")
+	}
+
+	// Little endian to big endian.
+	be := make([]byte, 0, size)
+	start := 0
+	if size > 4 {
+		start = 1
+	}
+	for i := size - 1; i >= start; i-- {
+		be = append(be, b[i])
+	}
+
+	n := new(big.Int).SetBytes(be)
+	if size <= 4 {
+		n.Rsh(n, 2)
+	}
+
+	return n, size, nil
+}
+
+// EncodeCompact returns the SCALE compact encoding of the non-negative integer n.
+func EncodeCompact(n *big.Int) []byte {
+	switch {
+	case n.Cmp(big.NewInt(1<<6)) < 0:
+		return []byte{byte(n.Uint64() << 2)}
+	case n.Cmp(big.NewInt(1<<14)) < 0:
+		v := n.Uint64()<<2 | 0b01
+		return []byte{byte(v), byte(v >> 8)}
+	case n.Cmp(big.NewInt(1<<30)) < 0:
+		v := n.Uint64()<<2 | 0b10
+		return []byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)}
+	}
+
+	be := n.Bytes()
+	out := []byte{byte(len(be)-4)<<2 | 0b11}
+	for i := len(be) - 1; i >= 0; i-- {
+		out = append(out, be[i])
+	}
+
+	return out
+}
+
+// SS58Encode returns the SS58 address of a 32 byte public key for the given network prefix.
+func SS58Encode(pub []byte, prefix uint16) string {
+	var payload []byte
+	if prefix < 64 {
+		payload = []byte{byte(prefix)}
+	} else {
+		payload = []byte{
+			byte((prefix&0b1111_1100)>>2) | 0b0100_0000,
+			byte(prefix>>8) | byte(prefix&0b11)<<6,
+		}
+	}
+	payload = append(payload, pub...)
+
+	checksum := blake2b.Sum512(append([]byte("SS58PRE"), payload...))
+	payload = append(payload, checksum[:2]...)
+
+	return base58(payload)
+}
+
+// SS58Decode returns the 32 byte public key and the network prefix of an SS58 address, verifying
+// its checksum.
+func SS58Decode(addr string) ([]byte, uint16, error) {
+	b, err := unbase58(addr)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(b) < 1 {
+		return nil, 0, errors.New("ss58: empty address")
+	}
+
+	var (
+		prefix    uint16
+		prefixLen = 1
+	)
+	switch {
+	case b[0] < 64:
+		prefix = uint16(b[0])
+	case b[0] < 128 && len(b) > 1:
+		prefixLen = 2
+		prefix = uint16(b[0]&0b0011_1111)<<2 | uint16(b[1]>>6) | uint16(b[1]&0b0011_1111)<<8
+	default:
+		return nil, 0, fmt.Errorf("ss58: invalid prefix byte %#x", b[0])
+	}
+	if len(b) != prefixLen+32+2 {
+		return nil, 0, fmt.Errorf("ss58: invalid length %d", len(b))
+	}
+
+	payload, checksum := b[:len(b)-2], b[len(b)-2:]
+	expected := blake2b.Sum512(append([]byte("SS58PRE"), payload...))
+	if !bytes.Equal(checksum, expected[:2]) {
+		return nil, 0, errors.New("ss58: invalid checksum")
+	}
+
+	return payload[prefixLen:], prefix, nil
+}
+
+func base58(b []byte) string {
+	n := new(big.Int).SetBytes(b)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for _, c := range b {
+		if c != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+
+	return string(out)
+}
+
+func unbase58(s string) ([]byte, error) {
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for _, c := range s {
+		i := strings.IndexRune(base58Alphabet, c)
+		if i < 0 {
+			return nil, fmt.Errorf("base58: invalid character %q", c)
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(i)))
+	}
+
+	var out []byte
+	for _, c := range s {
+		if c != rune(base58Alphabet[0]) {
+			break
+		}
+		out = append(out, 0)
+	}
+
+	return append(out, n.Bytes()...), nil
+}
@@ -0,0 +1,98 @@
+package substrate
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+func TestCompact(t *testing.T) {
+	tests := []struct {
+		n    string
+		want string
+	}{
+		{"0", "00"},
+		{"1", "04"},
+		{"63", "fc"},
+		{"64", "0101"},
+		{"16383", "fdff"},
+		{"16384", "02000100"},
+		{"1073741823", "feffffff"},
+		{"1073741824", "0300000040"},
+		{"18446744073709551615", "13ffffffffffffffff"},
+	}
+
+	for _, tt := range tests {
+		n, _ := new(big.Int).SetString(tt.n, 10)
+		encoded := EncodeCompact(n)
+		if got := hex.EncodeToString(encoded); got != tt.want {
+			t.Errorf("EncodeCompact(%s) = %s, want %s", tt.n, got, tt.want)
+		}
+
+		// Trailing bytes belong to the next field and must not be consumed.
+		decoded, size, err := DecodeCompact(append(encoded, 0xaa))
+		if err != nil {
+			t.Errorf("DecodeCompact(%s): %v", tt.want, err)
+			continue
+		}
+		if decoded.Cmp(n) != 0 || size != len(encoded) {
+			t.Errorf("DecodeCompact(%s) = %s, %d, want %s, %d", tt.want, decoded, size, tt.n, len(encoded))
+		}
+	}
+}
+
+func TestDecodeCompactErrors(t *testing.T) {
+	for _, b := range []string{"", "01", "020001", "13ffff"} {
+		data, _ := hex.DecodeString(b)
+		if _, _, err := DecodeCompact(data); err == nil {
+			t.Errorf("DecodeCompact(%q) succeeded, want error", b)
+		}
+	}
+}
+
+func TestSS58(t *testing.T) {
+	alice, _ := hex.DecodeString("d43593c715fdd31c61141abd04a99fd6822c8558854ccde39a5684e7a56da27d")
+
+	tests := []struct {
+		prefix uint16
+		want   string
+	}{
+		{42, "5GrwvaEF5zXb26Fz9rcQpDWS57CtERHpNehXCPcNoHGKutQY"},
+		{0, "15oF4uVJwmo4TdGW7VfQxNLavjCXviqxT9S1MgbjMNHr6Sp5"},
+		{2, "HNZata7iMYWmk5RvZRTiAsSDhV8366zq2YGb3tLH5Upf74F"},
+		// Two byte prefixes have no fixed vector here, so they are only round-tripped.
+		{64, ""},
+		{255, ""},
+		{16383, ""},
+	}
+
+	for _, tt := range tests {
+		addr := SS58Encode(alice, tt.prefix)
+		if tt.want != "" && addr != tt.want {
+			t.Errorf("SS58Encode(alice, %d) = %s, want %s", tt.prefix, addr, tt.want)
+		}
+
+		pub, prefix, err := SS58Decode(addr)
+		if err != nil {
+			t.Errorf("SS58Decode(%s): %v", addr, err)
+			continue
+		}
+		if !bytes.Equal(pub, alice) || prefix != tt.prefix {
+			t.Errorf("SS58Decode(%s) = %x, %d, want %x, %d", addr, pub, prefix, alice, tt.prefix)
+		}
+	}
+}
+
+func TestSS58DecodeErrors(t *testing.T) {
+	for _, addr := range []string{
+		"",
+		"5GrwvaEF5zXb26Fz9rcQpDWS57CtERHpNehXCPcNoHGKutQZ", // checksum
+		"5GrwvaEF5zXb26Fz9rcQpDWS57CtERHpNehXCPcNoHGKut0Y", // not base58
+		"5GrwvaEF5zXb26Fz9rcQpDWS57CtERHpNehXCPcNoHGKu",    // length
+	} {
+		if _, _, err := SS58Decode(addr); err == nil {
+			t.Errorf("SS58Decode(%q) succeeded, want error", addr)
+		}
+	}
+}
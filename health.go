@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/chains"
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/storage"
+)
+
+const (
+	defaultMinFreshFraction = 0.5
+	minFreshFractionEnvVar  = "READY_MIN_FRESH_FRACTION"
+	// A chain is fresh if it was updated within two refresh intervals, tolerating one failed refresh.
+	freshnessWindow = 2 * refreshInterval
+)
+
+type checkResult struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type refreshCheck struct {
+	checkResult
+	LastCompleted   *time.Time `json:"last_completed,omitempty"`
+	InProgressSince *time.Time `json:"in_progress_since,omitempty"`
+}
+
+type freshnessCheck struct {
+	checkResult
+	FreshFraction    float64 `json:"fresh_fraction"`
+	MinFreshFraction float64 `json:"min_fresh_fraction"`
+}
+
+type chainHealth struct {
+	ChainName  string     `json:"chain_name"`
+	ChainToken string     `json:"chain_token"`
+	Status     string     `json:"status"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+}
+
+type readiness struct {
+	Ready  bool `json:"ready"`
+	Checks struct {
+		Refresh   refreshCheck   `json:"refresh"`
+		Storage   checkResult    `json:"storage"`
+		Freshness freshnessCheck `json:"freshness"`
+	} `json:"checks"`
+	Chains []chainHealth `json:"chains"`
+}
+
+// registerHealthRoutes adds GET /healthz, reporting that the process is alive,
+// and GET /readyz, reporting whether the served coefficients can be relied upon.
+func registerHealthRoutes(r *gin.Engine, state *serverState, store *storage.FileStore, deps chains.Deps, minFreshFraction float64) {
+	r.GET("/healthz", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	r.GET("/readyz", func(c *gin.Context) {
+		resp := checkReadiness(state, store, deps.Clock(), minFreshFraction)
+
+		status := http.StatusOK
+		if !resp.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, resp)
+	})
+}
+
+// checkReadiness requires a refresh to have completed since startup, the snapshot storage to be
+// writable and at least minFreshFraction of all chains to have been updated within the freshness window.
+func checkReadiness(state *serverState, store *storage.FileStore, now time.Time, minFreshFraction float64) readiness {
+	var resp readiness
+
+	status := state.refreshStatus()
+	resp.Checks.Refresh.OK = !status.LastCompleted.IsZero()
+	if resp.Checks.Refresh.OK {
+		resp.Checks.Refresh.LastCompleted = &status.LastCompleted
+	} else {
+		resp.Checks.Refresh.Error = "no refresh has completed yet"
+	}
+	if !status.InProgressSince.IsZero() {
+		resp.Checks.Refresh.InProgressSince = &status.InProgressSince
+	}
+
+	resp.Checks.Storage.OK = true
+	if err := store.CheckWritable(); err != nil {
+		resp.Checks.Storage = checkResult{Error: err.Error()}
+	}
+
+	chainState, _ := state.get()
+	fresh := 0
+	for _, token := range chains.Tokens {
+		health := chainHealth{
+			ChainName:  token.ChainName(),
+			ChainToken: string(token),
+			Status:     "missing",
+		}
+
+		if chain, ok := chainState[token]; ok {
+			// Snapshots written before timestamps were recorded have no UpdatedAt and count as stale,
+			// and so do chains whose last refresh failed.
+			health.Status = "stale"
+			if !chain.UpdatedAt.IsZero() {
+				updatedAt := chain.UpdatedAt
+				health.UpdatedAt = &updatedAt
+				if !chain.Stale && now.Sub(updatedAt) <= freshnessWindow {
+					health.Status = "fresh"
+					fresh++
+				}
+			}
+		}
+
+		resp.Chains = append(resp.Chains, health)
+	}
+
+	resp.Checks.Freshness.MinFreshFraction = minFreshFraction
+	resp.Checks.Freshness.FreshFraction = float64(fresh) / float64(len(chains.Tokens))
+	resp.Checks.Freshness.OK = resp.Checks.Freshness.FreshFraction >= minFreshFraction
+	if !resp.Checks.Freshness.OK {
+		resp.Checks.Freshness.Error = "too few chains were updated recently"
+	}
+
+	resp.Ready = resp.Checks.Refresh.OK && resp.Checks.Storage.OK && resp.Checks.Freshness.OK
+
+	return resp
+}
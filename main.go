@@ -1,62 +1,189 @@
 package main
 
 import (
-	"fmt"
-	"github.com/gin-gonic/gin"
-	"github.com/xenowits/nakamoto-coefficient-calculator/core/chains"
+	"context"
+	"errors"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
 	"sort"
-	"sync"
+	"strconv"
+	"syscall"
 	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/chains"
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/storage"
+)
+
+const (
+	refreshInterval    = 6 * time.Hour
+	defaultStateFile   = "state.json"
+	defaultGracePeriod = 10 * time.Second
+	gracePeriodEnvVar  = "SHUTDOWN_GRACE_PERIOD"
+	stateFileEnvVar    = "STATE_FILE"
+	listenAddress      = ":8080"
 )
 
 type JsonResponse struct {
-	ChainName     string `json:"chain_name"`
-	ChainToken    string `json:"chain_token"`
-	NakaCoPrevVal int    `json:"naka_co_prev_val"`
-	NakaCoCurrVal int    `json:"naka_co_curr_val"`
-	Change        int    `json:"naka_co_change_val"`
+	ChainName     string          `json:"chain_name"`
+	ChainToken    string          `json:"chain_token"`
+	NakaCoPrevVal int             `json:"naka_co_prev_val"`
+	NakaCoCurrVal int             `json:"naka_co_curr_val"`
+	Change        int             `json:"naka_co_change_val"`
+	Metrics       []chains.Metric `json:"metrics,omitempty"`
+	Epoch         uint64          `json:"epoch,omitempty"`
+	Round         uint64          `json:"round,omitempty"`
+	Stale         bool            `json:"stale,omitempty"`
 }
 
 func main() {
-	var mu sync.Mutex
-	chainState := chains.NewState()
+	// Cancelled on SIGINT/SIGTERM, which stops in-flight refreshes.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	gracePeriod := defaultGracePeriod
+	if val := os.Getenv(gracePeriodEnvVar); val != "" {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			log.Fatalf("Invalid %s %q: %v", gracePeriodEnvVar, val, err)
+		}
+		gracePeriod = d
+	}
 
-	// Run a goroutine which refreshes state after every interval.
-	ticker := time.NewTicker(6 * time.Hour)
-	quit := make(chan struct{})
-	defer close(quit)
+	minFreshFraction := defaultMinFreshFraction
+	if val := os.Getenv(minFreshFractionEnvVar); val != "" {
+		f, err := strconv.ParseFloat(val, 64)
+		if err != nil || f < 0 || f > 1 {
+			log.Fatalf("Invalid %s %q: must be a number between 0 and 1", minFreshFractionEnvVar, val)
+		}
+		minFreshFraction = f
+	}
+
+	stateFile := defaultStateFile
+	if val := os.Getenv(stateFileEnvVar); val != "" {
+		stateFile = val
+	}
+
+	deps := chains.DepsFromEnv()
+	store := storage.NewFileStore(stateFile)
+
+	// Serve the last snapshot, if any, while the first refresh is running.
+	var initial chains.ChainState
+	snapshot, err := store.Load()
+	if err == nil {
+		log.Printf("Loaded %d chains from snapshot saved at %s", len(snapshot.Chains), snapshot.SavedAt.Format(time.RFC3339))
+		initial = snapshot.Chains
+	} else if !storage.IsNotExist(err) {
+		log.Println("Failed to load chain state snapshot:", err)
+	}
+	state := newServerState(initial)
+
+	// Run a goroutine which refreshes state immediately and after every interval.
+	refresherDone := make(chan struct{})
+	go func() {
+		defer close(refresherDone)
+
+		refresh := func() bool {
+			state.startRefresh(deps.Clock())
+			prevState, _ := state.get()
+			newState := chains.RefreshChainState(ctx, deps, prevState, state.update)
+			if ctx.Err() != nil {
+				log.Println("Refresh interrupted:", ctx.Err())
+				return false
+			}
+
+			state.set(newState, deps.Clock())
+			persist(store, deps, newState)
+
+			return true
+		}
+
+		if !refresh() {
+			return
+		}
+
+		ticker := time.NewTicker(refreshInterval)
+		defer ticker.Stop()
 
-	go func(state chains.ChainState) {
 		for {
 			select {
 			case <-ticker.C:
 				log.Println("Ticker ticked")
-				newState := chains.RefreshChainState(chainState)
-
-				mu.Lock()
-				chainState = newState
-				mu.Unlock()
-
-				fmt.Println(chainState)
-			case <-quit:
-				ticker.Stop()
+				if !refresh() {
+					return
+				}
+			case <-ctx.Done():
 				return
 			}
 		}
-	}(chainState)
+	}()
 
 	// Run server.
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
 	r.GET("/naka-coeffs", func(c *gin.Context) {
+		chainState, warming := state.get()
+		status := "ready"
+		if warming {
+			status = "warming"
+		}
+
 		coefficients := getListOfCoefficients(chainState)
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.JSON(200, gin.H{
+			"status":       status,
 			"coefficients": coefficients,
 		})
 	})
-	r.Run(":8080") // listen and serve on 0.0.0.0:8080 (for windows "localhost:8080")
+
+	registerHealthRoutes(r, state, store, deps, minFreshFraction)
+
+	srv := &http.Server{Addr: listenAddress, Handler: r}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- srv.ListenAndServe()
+	}()
+	log.Printf("Listening on %s", listenAddress)
+
+	select {
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalln("Server failed:", err)
+		}
+	case <-ctx.Done():
+	}
+
+	// Stop accepting requests, wait for the refresher to observe the cancellation
+	// and flush the state, all within the grace period.
+	stop()
+	log.Printf("Shutting down, grace period %s", gracePeriod)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Println("Server shutdown:", err)
+	}
+
+	select {
+	case <-refresherDone:
+	case <-shutdownCtx.Done():
+		log.Println("Refresher did not stop within the grace period")
+	}
+
+	// Chains updated by an interrupted refresh are kept, so nothing calculated is lost.
+	chainState, _ := state.get()
+	persist(store, deps, chainState)
+	log.Println("Shutdown complete")
+}
+
+// persist saves state to store, logging rather than failing on errors.
+func persist(store *storage.FileStore, deps chains.Deps, state chains.ChainState) {
+	err := store.Save(storage.Snapshot{SavedAt: deps.Clock(), Chains: state})
+	if err != nil {
+		log.Println("Failed to persist chain state:", err)
+	}
 }
 
 func getListOfCoefficients(state chains.ChainState) []JsonResponse {
@@ -68,6 +195,10 @@ func getListOfCoefficients(state chains.ChainState) []JsonResponse {
 			NakaCoPrevVal: chain.PrevNCVal,
 			NakaCoCurrVal: chain.CurrNCVal,
 			Change:        chain.CurrNCVal - chain.PrevNCVal,
+			Metrics:       chain.Metrics,
+			Epoch:         chain.Epoch,
+			Round:         chain.Round,
+			Stale:         chain.Stale,
 		})
 	}
 
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/xenowits/nakamoto-coefficient-calculator/core/chains"
+)
+
+// serverState holds the chain state served by the HTTP API.
+// The map is replaced rather than mutated, so it can be read without holding the lock.
+type serverState struct {
+	mu      sync.Mutex
+	chains  chains.ChainState
+	warming bool
+	refresh refreshStatus
+}
+
+// refreshStatus describes the progress of the background refresher.
+type refreshStatus struct {
+	// LastCompleted is when the last complete refresh finished, zero if none has yet.
+	LastCompleted time.Time
+	// InProgressSince is when the running refresh started, zero if none is running.
+	InProgressSince time.Time
+}
+
+// newServerState returns a state serving initial until the first refresh completes.
+func newServerState(initial chains.ChainState) *serverState {
+	if initial == nil {
+		initial = make(chains.ChainState)
+	}
+
+	return &serverState{chains: initial, warming: true}
+}
+
+// get returns the current chain state and whether the first refresh is still in progress.
+func (s *serverState) get() (chains.ChainState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.chains, s.warming
+}
+
+// refreshStatus returns the progress of the background refresher.
+func (s *serverState) refreshStatus() refreshStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.refresh
+}
+
+// startRefresh records that a refresh started at the given time.
+func (s *serverState) startRefresh(at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.refresh.InProgressSince = at
+}
+
+// set replaces the chain state with the result of a refresh completed at the given time.
+func (s *serverState) set(state chains.ChainState, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.chains = state
+	s.warming = false
+	s.refresh = refreshStatus{LastCompleted: at}
+}
+
+// update replaces a single chain, so that values are served as soon as they are calculated.
+func (s *serverState) update(token chains.Token, chain chains.Chain) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := make(chains.ChainState, len(s.chains)+1)
+	for t, c := range s.chains {
+		next[t] = c
+	}
+	next[token] = chain
+	s.chains = next
+}